@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"strings"
+)
+
+// auditFinding is a permission problem found by --audit-perms
+type auditFinding struct {
+	kind   string
+	path   string
+	detail string
+}
+
+// auditEntry records the permission problems of an entry of the walk:
+// world-writable entries (directories without the sticky bit), setuid and
+// setgid files, and entries owned by someone other than the owner of the
+// root. The walk audits entries before the filters that look at names and
+// contents, so binaries, the usual setuid files, are audited too.
+func (w *walkState) auditEntry(name, fullPath string, info fs.FileInfo) {
+	uid, hasOwner := statOwner(info)
+	if name == "." {
+		w.rootOwner, w.rootOwnerKnown = uid, hasOwner
+	}
+	mode := info.Mode()
+	if mode.Perm()&0o002 != 0 && !(info.IsDir() && mode&fs.ModeSticky != 0) {
+		w.findings = append(w.findings, auditFinding{"world-writable", fullPath, mode.String()})
+	}
+	if mode&fs.ModeSetuid != 0 {
+		w.findings = append(w.findings, auditFinding{"setuid", fullPath, mode.String()})
+	}
+	if mode&fs.ModeSetgid != 0 && !info.IsDir() {
+		w.findings = append(w.findings, auditFinding{"setgid", fullPath, mode.String()})
+	}
+	if w.rootOwnerKnown && hasOwner && uid != w.rootOwner {
+		w.findings = append(w.findings, auditFinding{"owner", fullPath, fmt.Sprintf("owned by %s, not %s", ownerName(uid), ownerName(w.rootOwner))})
+	}
+}
+
+// writeAuditFindings writes the findings of --audit-perms for one root
+func writeAuditFindings(w *strings.Builder, root *FileEntry) {
+	findings := root.findings
+	w.WriteString(fmt.Sprintf("- %s: %d\n", label("permission-findings"), len(findings)))
+	for _, finding := range findings {
+		w.WriteString(fmt.Sprintf("  - %s: %s (%s)\n", finding.kind, finding.path, finding.detail))
+	}
+}
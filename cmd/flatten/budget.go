@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// maxSuggestions caps the exclusions suggested after a truncated walk
+const maxSuggestions = 3
+
+// dirAggregate totals the files gathered below a directory
+type dirAggregate struct {
+	entry  *FileEntry
+	files  int
+	size   int64
+	tokens int
+}
+
+// aggregateDirs totals the files below entry, appending the totals of every
+// directory below it to aggs
+func aggregateDirs(entry *FileEntry, aggs *[]dirAggregate) dirAggregate {
+	if !entry.IsDir {
+		return dirAggregate{entry: entry, files: 1, size: entry.Size, tokens: entry.Tokens}
+	}
+	total := dirAggregate{entry: entry}
+	for _, child := range entry.Children {
+		sub := aggregateDirs(child, aggs)
+		total.files += sub.files
+		total.size += sub.size
+		total.tokens += sub.tokens
+	}
+	if entry.name != "." {
+		*aggs = append(*aggs, total)
+	}
+	return total
+}
+
+// suggestExclusions warns about the directories below root, loaded from
+// dir, whose exclusion would free the most of the --max-files budget after
+// a truncated walk, so the next run can be tuned in one step. Directories
+// holding every gathered file aren't suggested, as excluding them would
+// leave nothing. Only the gathered files are counted, so the savings are
+// lower bounds: the unwalked part of a directory may hold more.
+func suggestExclusions(dir string, root *FileEntry) {
+	var aggs []dirAggregate
+	total := aggregateDirs(root, &aggs)
+	// On ties the outer directory comes first, which also keeps a
+	// directory ahead of those inside it
+	sort.SliceStable(aggs, func(i, j int) bool {
+		if aggs[i].files != aggs[j].files {
+			return aggs[i].files > aggs[j].files
+		}
+		return strings.Count(aggs[i].entry.name, "/") < strings.Count(aggs[j].entry.name, "/")
+	})
+	var picked []string
+	for _, agg := range aggs {
+		if len(picked) == maxSuggestions {
+			break
+		}
+		if agg.files == 0 || agg.files == total.files || insideAny(agg.entry.name, picked) {
+			continue
+		}
+		picked = append(picked, agg.entry.name)
+		saved := formatBytes(agg.size)
+		if showTokens {
+			saved += fmt.Sprintf(", ~%s tokens", formatCount(agg.tokens))
+		}
+		warn(warnTruncated, filepath.Join(dir, filepath.FromSlash(agg.entry.name)), "excluding it with -E '%s/' would free at least %d of the %d files gathered (at least %s)", agg.entry.name, agg.files, total.files, saved)
+	}
+}
+
+// insideAny reports whether the slash-separated name lies in one of dirs
+func insideAny(name string, dirs []string) bool {
+	for _, dir := range dirs {
+		if strings.HasPrefix(name, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// formatCount abbreviates a count, e.g. 38k for 38211
+func formatCount(n int) string {
+	switch {
+	case n >= 1000000:
+		return fmt.Sprintf("%.1fM", float64(n)/1000000)
+	case n >= 1000:
+		return fmt.Sprintf("%dk", n/1000)
+	}
+	return fmt.Sprint(n)
+}
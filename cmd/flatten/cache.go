@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+)
+
+// metadataCacheVersion is bumped whenever cached fields change meaning, which
+// discards older caches
+const metadataCacheVersion = 1
+
+// cachedMetadata holds the derived fields of a file that are costly to
+// compute again
+type cachedMetadata struct {
+	MimeType string `json:"mime_type,omitempty"`
+	// Tokens is keyed by --tokens-model
+	Tokens map[string]int `json:"tokens,omitempty"`
+}
+
+// metadataCacheFile is the on-disk form of the --metadata-cache file
+type metadataCacheFile struct {
+	Version int                        `json:"version"`
+	Entries map[string]*cachedMetadata `json:"entries"`
+}
+
+// metadataStore is the cache of a run: the entries loaded from the file,
+// and those used during the run, which are all that is written back
+type metadataStore struct {
+	loaded map[string]*cachedMetadata
+	used   map[string]*cachedMetadata
+}
+
+var (
+	metadataCachePath string
+	metadataCache     *metadataStore
+)
+
+// metadataCacheKey identifies a file by device, inode, mtime and size, which
+// all stay put while the file is unchanged. It returns "" without a cache or
+// where the file system doesn't expose inodes.
+func metadataCacheKey(info fs.FileInfo) string {
+	if metadataCache == nil {
+		return ""
+	}
+	dev, ino, ok := statIdentity(info)
+	if !ok {
+		return ""
+	}
+	return fmt.Sprintf("%d:%d:%d:%d", dev, ino, info.ModTime().UnixNano(), info.Size())
+}
+
+// loadMetadataCache reads the --metadata-cache file. A missing file starts
+// an empty cache; an unreadable or outdated one is discarded with a warning.
+func loadMetadataCache() {
+	metadataCache = &metadataStore{
+		loaded: make(map[string]*cachedMetadata),
+		used:   make(map[string]*cachedMetadata),
+	}
+	data, err := os.ReadFile(metadataCachePath)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		warn(warnMetadataCache, metadataCachePath, "failed to read, starting afresh: %v", err)
+		return
+	}
+	var file metadataCacheFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		warn(warnMetadataCache, metadataCachePath, "failed to parse, starting afresh: %v", err)
+		return
+	}
+	if file.Version == metadataCacheVersion && file.Entries != nil {
+		metadataCache.loaded = file.Entries
+	}
+}
+
+// saveMetadataCache writes the entries used by the run back to the
+// --metadata-cache file, so files that are gone don't linger in it
+func saveMetadataCache() error {
+	if metadataCache == nil {
+		return nil
+	}
+	entries := make(map[string]*cachedMetadata, len(metadataCache.used))
+	for key, cached := range metadataCache.used {
+		if cached.MimeType != "" || len(cached.Tokens) > 0 {
+			entries[key] = cached
+		}
+	}
+	data, err := json.Marshal(metadataCacheFile{Version: metadataCacheVersion, Entries: entries})
+	if err != nil {
+		return fmt.Errorf("failed to encode metadata cache: %w", err)
+	}
+	tmp := metadataCachePath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write metadata cache: %w", err)
+	}
+	if err := os.Rename(tmp, metadataCachePath); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write metadata cache: %w", err)
+	}
+	return nil
+}
+
+// lookup returns the cached fields of the file with key, marking them as
+// used; it returns nil when nothing can be cached for the file
+func (s *metadataStore) lookup(key string) *cachedMetadata {
+	if s == nil || key == "" {
+		return nil
+	}
+	if cached, ok := s.used[key]; ok {
+		return cached
+	}
+	cached, ok := s.loaded[key]
+	if !ok {
+		cached = &cachedMetadata{}
+	}
+	s.used[key] = cached
+	return cached
+}
+
+// countTokens returns the token count of entry's content, from the cache
+// when the file is unchanged
+func countTokens(w *walkState, entry *FileEntry) (int, error) {
+	cached := metadataCache.lookup(entry.cacheKey)
+	if n, ok := cached.tokens(); ok {
+		return n, nil
+	}
+	content, err := entry.ReadContent()
+	if err != nil {
+		return 0, err
+	}
+	n := len(w.tokenizer.Encode(string(content), nil, nil))
+	// A file that changed while it was read no longer matches its key
+	if cached != nil && !entry.changed {
+		if cached.Tokens == nil {
+			cached.Tokens = make(map[string]int)
+		}
+		cached.Tokens[tokensModel] = n
+	}
+	return n, nil
+}
+
+func (c *cachedMetadata) tokens() (int, bool) {
+	if c == nil {
+		return 0, false
+	}
+	n, ok := c.Tokens[tokensModel]
+	return n, ok
+}
+
+// mimeTypeOf identifies entry's file from its first bytes, as returned by
+// head, or takes the type from the cache when the file is unchanged, in
+// which case head isn't called. Types found with --magic-file aren't cached,
+// as they depend on that file.
+func mimeTypeOf(entry *FileEntry, head func() ([]byte, error)) (string, error) {
+	var cached *cachedMetadata
+	if magicFile == "" {
+		cached = metadataCache.lookup(entry.cacheKey)
+	}
+	if cached != nil && cached.MimeType != "" {
+		return cached.MimeType, nil
+	}
+	content, err := head()
+	if err != nil {
+		return "", err
+	}
+	mimeType := guessMimeType(entry.Path, content)
+	if cached != nil && !entry.changed {
+		cached.MimeType = mimeType
+	}
+	return mimeType, nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"strings"
+)
+
+// Content-defined chunking parameters. Chunks end on line boundaries so that
+// collapsed regions never split a line, and a boundary is placed after a
+// line whose hash has its low bits clear, which makes boundaries depend on
+// content rather than offsets: an insertion only disturbs nearby chunks.
+const (
+	chunkMinSize = 512
+	chunkMaxSize = 8 * 1024
+	chunkMask    = 7
+	// chunkDedupMinFile is the smallest file worth chunking
+	chunkDedupMinFile = 4 * 1024
+	// chunkDedupMinShare is the share of a file that must be found elsewhere
+	// before it is reported as partially identical
+	chunkDedupMinShare = 0.5
+)
+
+// splitChunks cuts content into line-aligned, content-defined chunks
+func splitChunks(content []byte) [][]byte {
+	var chunks [][]byte
+	start := 0
+	for pos := 0; pos < len(content); {
+		end := bytes.IndexByte(content[pos:], '\n')
+		if end < 0 {
+			end = len(content)
+		} else {
+			end += pos + 1
+		}
+		line := content[pos:end]
+		pos = end
+		size := pos - start
+		if size < chunkMinSize && pos < len(content) {
+			continue
+		}
+		h := fnv.New32a()
+		h.Write(line)
+		if h.Sum32()&chunkMask == 0 || size >= chunkMaxSize || pos == len(content) {
+			chunks = append(chunks, content[start:pos])
+			start = pos
+		}
+	}
+	return chunks
+}
+
+// chunkIndex remembers the first file each chunk was seen in
+type chunkIndex map[string]string
+
+// chunkRun is a sequence of consecutive chunks that are either all new or
+// all found in the same earlier file
+type chunkRun struct {
+	data   []byte
+	source string
+}
+
+// match splits content into runs of new and previously seen chunks, and
+// registers its chunks for later files. It returns the runs, the number of
+// shared bytes, and the file sharing the most bytes.
+func (idx chunkIndex) match(path string, content []byte) ([]chunkRun, int, string) {
+	var runs []chunkRun
+	shared := 0
+	bySource := make(map[string]int)
+	offset := 0
+	for _, chunk := range splitChunks(content) {
+		start := offset
+		offset += len(chunk)
+		key := calculateFileHash(chunk)
+		source, seen := idx[key]
+		if !seen {
+			idx[key] = path
+		} else {
+			shared += len(chunk)
+			bySource[source] += len(chunk)
+		}
+		// Chunks are consecutive slices of content, so a run just grows
+		if n := len(runs); n > 0 && runs[n-1].source == source {
+			runs[n-1].data = content[offset-len(runs[n-1].data)-len(chunk) : offset]
+			continue
+		}
+		runs = append(runs, chunkRun{data: content[start:offset], source: source})
+	}
+	best := ""
+	for source, n := range bySource {
+		if best == "" || n > bySource[best] || (n == bySource[best] && source < best) {
+			best = source
+		}
+	}
+	return runs, shared, best
+}
+
+// renderPartial renders content with its shared runs collapsed into markers
+func renderPartial(runs []chunkRun) string {
+	var sb strings.Builder
+	for _, run := range runs {
+		if run.source == "" {
+			sb.Write(run.data)
+			continue
+		}
+		lines := bytes.Count(run.data, []byte("\n"))
+		sb.WriteString(fmt.Sprintf("[... %d lines (%s) identical to %s ...]\n", lines, formatBytes(int64(len(run.data))), run.source))
+	}
+	return strings.TrimSuffix(sb.String(), "\n")
+}
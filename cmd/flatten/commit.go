@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// writeTreeWith writes a git tree that is base (a tree hash, or "" for an
+// empty tree) with the blob stored at the path given by parts, creating or
+// replacing the subtrees along the way, and returns its hash
+func writeTreeWith(repo string, base string, parts []string, blob string) (string, error) {
+	var entries []string
+	var subtree string
+	if base != "" {
+		out, err := runGit(repo, "ls-tree", "-z", base)
+		if err != nil {
+			return "", err
+		}
+		for _, entry := range splitNul(out) {
+			meta, name, ok := strings.Cut(entry, "\t")
+			if !ok {
+				continue
+			}
+			if name == parts[0] {
+				if fields := strings.Fields(meta); len(parts) > 1 && len(fields) == 3 && fields[1] == "tree" {
+					subtree = fields[2]
+				}
+				continue
+			}
+			entries = append(entries, entry)
+		}
+	}
+	if len(parts) == 1 {
+		entries = append(entries, fmt.Sprintf("100644 blob %s\t%s", blob, parts[0]))
+	} else {
+		tree, err := writeTreeWith(repo, subtree, parts[1:], blob)
+		if err != nil {
+			return "", err
+		}
+		entries = append(entries, fmt.Sprintf("040000 tree %s\t%s", tree, parts[0]))
+	}
+	var input bytes.Buffer
+	for _, entry := range entries {
+		input.WriteString(entry + "\x00")
+	}
+	out, err := runGitInput(repo, input.Bytes(), "mktree", "-z")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// commitSnapshot commits content as file on branch, without touching the
+// index or the working tree. The branch is created as an orphan branch when
+// it doesn't exist. It returns the new commit, or "" when the snapshot is
+// unchanged since the branch's last commit.
+func commitSnapshot(repo string, branch string, file string, message string, content []byte) (string, error) {
+	ref := "refs/heads/" + branch
+	if _, err := runGit(repo, "check-ref-format", ref); err != nil {
+		return "", fmt.Errorf("invalid branch name %q", branch)
+	}
+	if head, err := runGit(repo, "symbolic-ref", "-q", "HEAD"); err == nil && strings.TrimSpace(string(head)) == ref {
+		return "", fmt.Errorf("%s is checked out; snapshots go to a dedicated branch", branch)
+	}
+	parent, parentTree := "", ""
+	if out, err := runGit(repo, "rev-parse", "-q", "--verify", ref+"^{commit}"); err == nil {
+		parent = strings.TrimSpace(string(out))
+		out, err := runGit(repo, "rev-parse", parent+"^{tree}")
+		if err != nil {
+			return "", err
+		}
+		parentTree = strings.TrimSpace(string(out))
+	}
+	out, err := runGitInput(repo, content, "hash-object", "-w", "--stdin")
+	if err != nil {
+		return "", err
+	}
+	blob := strings.TrimSpace(string(out))
+	tree, err := writeTreeWith(repo, parentTree, strings.Split(file, "/"), blob)
+	if err != nil {
+		return "", err
+	}
+	if tree == parentTree {
+		return "", nil
+	}
+	args := []string{"commit-tree", tree, "-m", message}
+	if parent != "" {
+		args = append(args, "-p", parent)
+	}
+	out, err = runGit(repo, args...)
+	if err != nil {
+		return "", err
+	}
+	commit := strings.TrimSpace(string(out))
+	// The old value guards against the branch moving since it was read; an
+	// empty one requires that it still doesn't exist
+	if _, err := runGit(repo, "update-ref", ref, commit, parent); err != nil {
+		return "", err
+	}
+	return commit, nil
+}
+
+var (
+	commitBranch  string
+	commitPath    string
+	commitMessage string
+	commitInput   string
+	commitRepo    string
+)
+
+var commitCmd = &cobra.Command{
+	Use:   "commit --branch <branch>",
+	Short: "Commit flatten output to a dedicated snapshot branch",
+	Long: `Commit reads flatten output (from stdin or --input), in any format, and
+commits it as a single file onto a dedicated branch of the repository, which
+is created as an orphan branch the first time. The index, the working tree
+and the checked-out branch are left alone, so snapshots get a versioned
+history without polluting the working branch:
+
+  flatten --reproducible . | flatten commit --branch snapshots -m "Nightly snapshot"`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if commitBranch == "" {
+			return fmt.Errorf("--branch is required")
+		}
+		if commitPath != path.Clean(commitPath) || !isLocalSlash(commitPath) {
+			return fmt.Errorf("invalid --path %q: it must be a relative path inside the branch", commitPath)
+		}
+		var in io.Reader = os.Stdin
+		if commitInput != "" {
+			file, err := os.Open(commitInput)
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			in = file
+		}
+		content, err := io.ReadAll(in)
+		if err != nil {
+			return fmt.Errorf("failed to read the snapshot: %w", err)
+		}
+		message := commitMessage
+		if message == "" {
+			message = "Snapshot " + time.Now().UTC().Format(time.RFC3339)
+		}
+		commit, err := commitSnapshot(commitRepo, commitBranch, commitPath, message, content)
+		if err != nil {
+			return fmt.Errorf("failed to commit the snapshot: %w", err)
+		}
+		if commit == "" {
+			fmt.Fprintf(os.Stderr, "%s is unchanged on %s; nothing committed\n", commitPath, commitBranch)
+			return nil
+		}
+		fmt.Fprintf(os.Stderr, "committed %s to %s as %s\n", commitPath, commitBranch, commit[:12])
+		return nil
+	},
+}
+
+// isLocalSlash reports whether a slash-separated path stays inside its base
+func isLocalSlash(p string) bool {
+	return p != "" && p != "." && !path.IsAbs(p) && p != ".." && !strings.HasPrefix(p, "../")
+}
+
+func init() {
+	commitCmd.Flags().StringVar(&commitBranch, "branch", "", "Branch to commit the snapshot to")
+	commitCmd.Flags().StringVar(&commitPath, "path", "flatten.txt", "Path of the snapshot file in the branch")
+	commitCmd.Flags().StringVarP(&commitMessage, "message", "m", "", "Commit message (default \"Snapshot <time>\")")
+	commitCmd.Flags().StringVar(&commitInput, "input", "", "Read the snapshot from this file instead of stdin")
+	commitCmd.Flags().StringVar(&commitRepo, "repo", ".", "Repository to commit to")
+	rootCmd.AddCommand(commitCmd)
+}
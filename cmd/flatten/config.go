@@ -0,0 +1,256 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+)
+
+// configFile is the name of the settings file looked up from the flattened
+// directory up to the file system root
+const configFile = ".flatten.yaml"
+
+var noConfig bool
+
+// configKeys are the flags a .flatten.yaml may set: options that choose and
+// render files. A settings file comes with the tree being flattened, so
+// flags that run commands, fetch, read or write other paths, or loosen the
+// symlink confinement are only taken from the command line.
+var configKeys = map[string]bool{
+	"include-gitignore": true, "include-git": true, "git-parts": true, "include-bin": true,
+	"untracked-only": true, "sparse": true, "git-status": true,
+	"include": true, "exclude": true, "without": true, "ext": true,
+	"exclude-content": true, "content-head": true, "skip-empty": true,
+	"max-files": true, "on-max-files": true, "sample-files": true, "timeout": true, "quick": true,
+
+	"no-dedup": true, "dedup-style": true, "file-ids": true, "chunk-dedup": true, "summarize-over": true,
+	"order": true, "file-header": true, "readme-first": true, "dir-banners": true,
+	"last-updated": true, "show-mode": true, "editorconfig": true, "show-interpreter": true,
+	"show-size": true, "disk-usage": true, "show-mime": true, "show-symlinks": true,
+	"show-owner": true, "numeric-owner": true, "show-checksum": true, "all-metadata": true, "metadata": true,
+	"tokens": true, "tokens-model": true, "audit-perms": true, "fs-info": true, "freshness": true,
+	"tree-format": true, "tree-only": true, "reproducible": true, "no-header": true, "no-tree": true,
+	"output-labels": true, "porcelain": true, "format": true, "dir-records": true, "null": true, "list-only": true,
+}
+
+// configLayer is the settings of one .flatten.yaml file
+type configLayer struct {
+	file     string
+	settings map[string]any
+}
+
+// findConfig returns the .flatten.yaml files that apply to dir, outermost
+// first. The search stops at a file setting "root: true".
+func findConfig(dir string) ([]configLayer, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	var layers []configLayer
+	for {
+		file := filepath.Join(abs, configFile)
+		if _, err := os.Stat(file); err == nil {
+			settings, err := readConfig(file)
+			if err != nil {
+				return nil, err
+			}
+			layers = append([]configLayer{{file, settings}}, layers...)
+			if isRoot, _ := settings["root"].(bool); isRoot {
+				break
+			}
+		}
+		parent := filepath.Dir(abs)
+		if parent == abs {
+			break
+		}
+		abs = parent
+	}
+	return layers, nil
+}
+
+// readConfig parses a settings file into its top-level keys
+func readConfig(file string) (map[string]any, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", file, err)
+	}
+	settings := make(map[string]any)
+	if err := yaml.Unmarshal(data, &settings); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", file, err)
+	}
+	return settings, nil
+}
+
+// configValues turns a setting into the values of its flag: one per list
+// item, "key=value" per map entry, or the scalar itself
+func configValues(value any) []string {
+	switch v := value.(type) {
+	case []any:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			values = append(values, fmt.Sprint(item))
+		}
+		return values
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		values := make([]string, 0, len(v))
+		for _, key := range keys {
+			values = append(values, fmt.Sprintf("%s=%v", key, v[key]))
+		}
+		return values
+	default:
+		return []string{fmt.Sprint(v)}
+	}
+}
+
+// applyConfig sets flags from the .flatten.yaml files that apply
+// to dir. Keys are long flag names. Files are merged from the outermost to
+// the innermost: scalars in inner files override outer ones, while lists,
+// such as exclude patterns, accumulate. Flags given on the command line win
+// over every file.
+func applyConfig(flags *pflag.FlagSet, dir string) error {
+	layers, err := findConfig(dir)
+	if err != nil {
+		return err
+	}
+	merged := make(map[string][]string)
+	var order []string
+	for _, layer := range layers {
+		keys := make([]string, 0, len(layer.settings))
+		for key := range layer.settings {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if key == "root" {
+				continue
+			}
+			if key == "exclude-groups" {
+				if err := addExcludeGroups(layer.file, layer.settings[key]); err != nil {
+					return err
+				}
+				continue
+			}
+			flag := flags.Lookup(key)
+			if flag == nil {
+				return fmt.Errorf("%s: unknown setting %q", layer.file, key)
+			}
+			if !configKeys[key] {
+				return fmt.Errorf("%s: %q can't be set in %s; pass --%s on the command line", layer.file, key, configFile, key)
+			}
+			if _, ok := merged[key]; !ok {
+				order = append(order, key)
+			}
+			values := configValues(layer.settings[key])
+			if _, isSlice := flag.Value.(pflag.SliceValue); isSlice {
+				merged[key] = append(merged[key], values...)
+			} else {
+				merged[key] = values
+			}
+		}
+	}
+	for _, key := range order {
+		flag := flags.Lookup(key)
+		if flag.Changed {
+			continue
+		}
+		values := merged[key]
+		if sv, ok := flag.Value.(pflag.SliceValue); ok {
+			err = sv.Replace(values)
+		} else {
+			err = flag.Value.Set(strings.Join(values, ","))
+		}
+		if err != nil {
+			return fmt.Errorf("invalid %s setting in %s: %w", key, configFile, err)
+		}
+		flag.Changed = true
+	}
+	return nil
+}
+
+// excludeGroup is a named, documented set of exclude patterns defined in a
+// settings file and applied with --without
+type excludeGroup struct {
+	description string
+	patterns    []string
+}
+
+var (
+	excludeGroups = make(map[string]*excludeGroup)
+	withoutGroups []string
+)
+
+// addExcludeGroups reads the exclude-groups setting of a file. Each group is
+// either a list of patterns or a map with a description and patterns; a
+// group defined again in an inner file replaces the outer definition.
+func addExcludeGroups(file string, value any) error {
+	groups, ok := value.(map[string]any)
+	if !ok {
+		return fmt.Errorf("%s: exclude-groups must map group names to patterns", file)
+	}
+	for name, def := range groups {
+		group := &excludeGroup{}
+		switch d := def.(type) {
+		case []any:
+			group.patterns = configValues(d)
+		case map[string]any:
+			for key, v := range d {
+				switch key {
+				case "description":
+					group.description = fmt.Sprint(v)
+				case "patterns":
+					group.patterns = configValues(v)
+				default:
+					return fmt.Errorf("%s: unknown setting %q in exclude group %q", file, key, name)
+				}
+			}
+		default:
+			return fmt.Errorf("%s: exclude group %q must be a list of patterns or have patterns and a description", file, name)
+		}
+		excludeGroups[name] = group
+	}
+	return nil
+}
+
+// applyExcludeGroups adds the patterns of the groups named by --without to
+// the exclude patterns
+func applyExcludeGroups() error {
+	for _, name := range withoutGroups {
+		group, ok := excludeGroups[name]
+		if !ok {
+			return fmt.Errorf("unknown exclude group %q%s", name, describeExcludeGroups())
+		}
+		excludePatterns = append(excludePatterns, group.patterns...)
+	}
+	return nil
+}
+
+// describeExcludeGroups lists the defined groups for an error message
+func describeExcludeGroups() string {
+	if len(excludeGroups) == 0 {
+		return "; no exclude-groups are defined in " + configFile
+	}
+	names := make([]string, 0, len(excludeGroups))
+	for name := range excludeGroups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var sb strings.Builder
+	sb.WriteString("; defined groups:")
+	for _, name := range names {
+		sb.WriteString("\n  " + name)
+		if description := excludeGroups[name].description; description != "" {
+			sb.WriteString(": " + description)
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+)
+
+var (
+	excludeContent []string
+	contentHead    int
+	// contentPatterns are the compiled --exclude-content expressions
+	contentPatterns []*regexp.Regexp
+)
+
+// compileContentPatterns checks and compiles the --exclude-content
+// expressions
+func compileContentPatterns() error {
+	if contentHead <= 0 {
+		return fmt.Errorf("--content-head must be positive")
+	}
+	contentPatterns = nil
+	for _, expr := range excludeContent {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return fmt.Errorf("invalid --exclude-content expression %q: %w", expr, err)
+		}
+		contentPatterns = append(contentPatterns, re)
+	}
+	return nil
+}
+
+// matchesContent reports whether the first contentHead bytes of the file
+// at path match one of the content patterns, e.g. the header comment that
+// marks a vendored or generated file
+func (f *Filter) matchesContent(path string) (bool, error) {
+	rel, err := filepath.Rel(f.baseDir, path)
+	if err != nil {
+		return false, err
+	}
+	file, err := f.fsys.Open(filepath.ToSlash(rel))
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+	head, err := io.ReadAll(io.LimitReader(file, int64(f.contentHead)))
+	if err != nil {
+		return false, err
+	}
+	for _, re := range f.contentPatterns {
+		if re.Match(head) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// dedupStyles lists the values of --dedup-style
+var dedupStyles = []string{"reference", "footnote", "omit"}
+
+var dedupStyle string
+
+// validateDedupStyle checks the --dedup-style value
+func validateDedupStyle() error {
+	for _, style := range dedupStyles {
+		if dedupStyle == style {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid --dedup-style value %q (valid: %s)", dedupStyle, strings.Join(dedupStyles, ", "))
+}
+
+// footnote returns the marker that stands in for the content of a duplicate
+// of reference under --dedup-style footnote. Duplicates of the same file
+// share a number.
+func (s *renderState) footnote(reference string) string {
+	if s.footnotes == nil {
+		s.footnotes = make(map[string]int)
+	}
+	n, ok := s.footnotes[reference]
+	if !ok {
+		s.footnoteOrder = append(s.footnoteOrder, reference)
+		n = len(s.footnoteOrder)
+		s.footnotes[reference] = n
+	}
+	return fmt.Sprintf("[^%d]", n)
+}
+
+// writeFootnotes resolves the footnotes of the duplicates after the contents
+func writeFootnotes(w *strings.Builder, state *renderState) {
+	if len(state.footnoteOrder) == 0 {
+		return
+	}
+	w.WriteString(fmt.Sprintf("\n- %s:\n", label("footnotes")))
+	for i, reference := range state.footnoteOrder {
+		w.WriteString(fmt.Sprintf("[^%d]: %s\n", i+1, identicalTo(reference)))
+	}
+}
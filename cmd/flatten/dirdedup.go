@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash/fnv"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// shapeDirs keys every directory below entry by the names, types and sizes
+// of its subtree, without reading any file, and records in shapes the
+// directories that hold files and share their key with another one: only
+// those can repeat an earlier directory. It returns the key of entry and
+// how many files lie below it.
+func shapeDirs(entry *FileEntry, shapes map[*FileEntry]string, counts map[string]int) (string, int) {
+	if !entry.IsDir {
+		return fmt.Sprintf("file %d", entry.Size), 1
+	}
+	hasher := fnv.New64a()
+	files := 0
+	for _, child := range sortedByName(entry.Children) {
+		if interrupted() {
+			return "", 0
+		}
+		shape, n := shapeDirs(child, shapes, counts)
+		files += n
+		fmt.Fprintf(hasher, "%s\x00%s\n", filepath.Base(child.Path), shape)
+	}
+	shape := "dir " + hex.EncodeToString(hasher.Sum(nil))
+	if files > 0 {
+		shapes[entry] = shape
+		counts[shape]++
+	}
+	return shape, files
+}
+
+// repeatableDirs returns the shape of every directory below root that may
+// repeat another one
+func repeatableDirs(root *FileEntry) map[*FileEntry]string {
+	shapes := make(map[*FileEntry]string)
+	counts := make(map[string]int)
+	shapeDirs(root, shapes, counts)
+	for entry, shape := range shapes {
+		if counts[shape] < 2 {
+			delete(shapes, entry)
+		}
+	}
+	return shapes
+}
+
+// sortedByName returns children ordered by name, whatever order the output
+// uses
+func sortedByName(children []*FileEntry) []*FileEntry {
+	sorted := append([]*FileEntry{}, children...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return filepath.Base(sorted[i].Path) < filepath.Base(sorted[j].Path)
+	})
+	return sorted
+}
+
+// fileKey returns the dedup key of a file, reusing the one computed when
+// it was written. It returns false when the file can't be read.
+func (s *renderState) fileKey(entry *FileEntry) (string, bool) {
+	if key, ok := s.fileKeys[entry]; ok {
+		return key, true
+	}
+	content, err := entry.ReadContent()
+	if err != nil {
+		return "", false
+	}
+	return s.contentKey(entry, content), true
+}
+
+// contentKey returns the dedup key of a file's content, remembering it
+// while directories are deduplicated
+func (s *renderState) contentKey(entry *FileEntry, content []byte) string {
+	if key, ok := s.fileKeys[entry]; ok {
+		return key
+	}
+	key := dedupKey(content)
+	if s.fileKeys != nil {
+		s.fileKeys[entry] = key
+	}
+	return key
+}
+
+// dirKey hashes the names and file dedup keys below entry. It returns
+// false when a file can't be read or the run was interrupted.
+func (s *renderState) dirKey(entry *FileEntry) (string, bool) {
+	if !entry.IsDir {
+		key, ok := s.fileKey(entry)
+		return "file " + key, ok
+	}
+	if key, ok := s.dirKeys[entry]; ok {
+		return key, true
+	}
+	hasher := sha256.New()
+	for _, child := range sortedByName(entry.Children) {
+		if interrupted() {
+			return "", false
+		}
+		key, ok := s.dirKey(child)
+		if !ok {
+			return "", false
+		}
+		fmt.Fprintf(hasher, "%s\x00%s\n", filepath.Base(child.Path), key)
+	}
+	key := "dir " + hex.EncodeToString(hasher.Sum(nil))
+	s.dirKeys[entry] = key
+	return key, true
+}
+
+// identicalDir returns the earlier directory whose subtree entry repeats,
+// or nil. Only directories shaped like an earlier one are compared, by the
+// dedup keys of their files.
+func (s *renderState) identicalDir(entry *FileEntry) *FileEntry {
+	shape, ok := s.dirShapes[entry]
+	if !ok {
+		return nil
+	}
+	earlier := s.seenDirs[shape]
+	if len(earlier) > 0 {
+		if key, ok := s.dirKey(entry); ok {
+			for _, dir := range earlier {
+				if other, ok := s.dirKey(dir); ok && other == key && sameContents(entry, dir) {
+					return dir
+				}
+			}
+		}
+	}
+	s.seenDirs[shape] = append(earlier, entry)
+	return nil
+}
+
+// sameContents confirms that two directories with the same keys hold the
+// same files, as findDuplicate confirms FNV matches
+func sameContents(a, b *FileEntry) bool {
+	if showChecksum {
+		return true
+	}
+	if !a.IsDir {
+		contentA, errA := a.ReadContent()
+		contentB, errB := b.ReadContent()
+		return errA == nil && errB == nil && bytes.Equal(contentA, contentB)
+	}
+	childrenA, childrenB := sortedByName(a.Children), sortedByName(b.Children)
+	if len(childrenA) != len(childrenB) {
+		return false
+	}
+	for i := range childrenA {
+		if !sameContents(childrenA[i], childrenB[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// printIdenticalDir writes a directory whose subtree repeats an earlier one
+// as a single reference to it instead of repeating every file, and reports
+// whether it did
+func printIdenticalDir(entry *FileEntry, w *strings.Builder, state *renderState) bool {
+	if state.dirShapes == nil {
+		return false
+	}
+	first := state.identicalDir(entry)
+	if first == nil {
+		return false
+	}
+	state.Dirs++
+	state.Files += getTotalFiles(entry)
+	state.Bytes += getTotalSize(entry)
+	if dedupStyle == "omit" || interrupted() {
+		return true
+	}
+	printBanner(entry.Path, w, state)
+	header := &fileHeader{}
+	header.add("path", entry.Path)
+	if dedupStyle == "footnote" {
+		header.add("content", state.footnote(first.Path))
+	} else {
+		header.add("content", strings.ReplaceAll(label("identical-dir"), "{path}", first.Path))
+	}
+	header.write(w, "", "")
+	return true
+}
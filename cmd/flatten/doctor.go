@@ -0,0 +1,233 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// Thresholds above which doctor advises narrowing a run
+const (
+	doctorLargeOutput = 10 * 1024 * 1024
+	doctorLargeFile   = 1024 * 1024
+	// doctorBytesPerToken is a rough average for source code and prose
+	doctorBytesPerToken = 4
+)
+
+// doctorReport collects what doctor found about a directory
+type doctorReport struct {
+	dir         string
+	topLevel    string
+	sparse      *sparseCone
+	submodules  []submodule
+	ignoreFiles []string
+	files       int
+	size        int64
+	largest     []*FileEntry
+	exclusions  map[string]int
+	estimate    int64
+}
+
+// diagnose walks dir with the default filters, without reading contents
+func diagnose(dir string) (*doctorReport, error) {
+	if _, err := os.ReadDir(dir); err != nil {
+		return nil, fmt.Errorf("%s is not readable: %w", dir, err)
+	}
+	report := &doctorReport{dir: dir}
+	if out, err := runGit(dir, "rev-parse", "--show-toplevel"); err == nil {
+		report.topLevel = strings.TrimSpace(string(out))
+		report.submodules = gitSubmodules(dir)
+		report.ignoreFiles = applicableIgnoreFiles(dir, report.topLevel)
+	}
+	sparse, err := gitSparseCone(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sparse-checkout state: %w", err)
+	}
+	report.sparse = sparse
+
+	filter, err := NewFilter(dir, filterOptions())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create filter for %s: %w", dir, err)
+	}
+	realRoot, err := realPath(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s: %w", dir, err)
+	}
+	root, err := loadDirectory(&walkState{
+		fsys:     os.DirFS(dir),
+		root:     dir,
+		realRoot: realRoot,
+		filter:   filter,
+		limits:   &walkLimits{},
+	}, ".")
+	if err != nil {
+		return nil, fmt.Errorf("failed to load directory structure for %s: %w", dir, err)
+	}
+	report.exclusions = filter.Exclusions()
+	if root == nil {
+		return report, nil
+	}
+	report.collect(root)
+	sort.Slice(report.largest, func(i, j int) bool {
+		return report.largest[i].Size > report.largest[j].Size
+	})
+	return report, nil
+}
+
+// collect tallies the included files, .gitignore files inside the tree and
+// the estimated output size, which adds each file's path and fences to its
+// content
+func (r *doctorReport) collect(entry *FileEntry) {
+	if entry.IsDir {
+		for _, child := range entry.Children {
+			r.collect(child)
+		}
+		return
+	}
+	r.files++
+	r.size += entry.Size
+	r.estimate += entry.Size + int64(2*len(entry.Path)+32)
+	if entry.Size >= doctorLargeFile {
+		r.largest = append(r.largest, entry)
+	}
+	if r.topLevel == "" && filepath.Base(entry.Path) == ".gitignore" {
+		r.ignoreFiles = append(r.ignoreFiles, entry.Path)
+	}
+}
+
+// applicableIgnoreFiles lists the ignore files git consults for dir: the
+// global excludes file, info/exclude, and the .gitignore files from the
+// repository root down through dir
+func applicableIgnoreFiles(dir string, topLevel string) []string {
+	var files []string
+	exists := func(file string) bool {
+		info, err := os.Stat(file)
+		return err == nil && !info.IsDir()
+	}
+	if file := globalExcludesFile(dir); file != "" && exists(file) {
+		files = append(files, file)
+	}
+	if _, commonDir, err := resolveGitDir(topLevel); err == nil && commonDir != "" {
+		if file := filepath.Join(commonDir, "info", "exclude"); exists(file) {
+			files = append(files, file)
+		}
+	}
+	if out, err := runGit(dir, "ls-files", "-z", "--cached", "--others", "--exclude-standard", "--", ":(glob)**/.gitignore"); err == nil {
+		for _, file := range splitNul(out) {
+			files = append(files, filepath.Join(dir, filepath.FromSlash(file)))
+		}
+	}
+	// .gitignore files above dir apply too
+	if abs, err := filepath.Abs(dir); err == nil {
+		for parent := filepath.Dir(abs); isWithin(parent, topLevel); parent = filepath.Dir(parent) {
+			if file := filepath.Join(parent, ".gitignore"); exists(file) {
+				files = append(files, file)
+			}
+			if parent == topLevel {
+				break
+			}
+		}
+	}
+	return files
+}
+
+// advice turns the report into suggestions for the actual run
+func (r *doctorReport) advice() []string {
+	var advice []string
+	if r.topLevel == "" {
+		advice = append(advice, "Not a git repository: only .gitignore files inside it are honored, and --untracked-only, --sparse and --submodules have no effect.")
+	}
+	if r.files == 0 {
+		advice = append(advice, "No files would be included; check --include patterns, or --include-gitignore if everything is ignored.")
+	}
+	if r.estimate >= doctorLargeOutput {
+		advice = append(advice, "The output will be large: start with --tree-only, narrow it with -I/-E, or cap it with --max-files.")
+	}
+	for i, entry := range r.largest {
+		if i == 3 {
+			break
+		}
+		advice = append(advice, fmt.Sprintf("%s is %s; exclude it with -E '%s' if it isn't needed.", entry.Path, formatBytes(entry.Size), filepath.Base(entry.Path)))
+	}
+	uninitialized := 0
+	for _, sub := range r.submodules {
+		if !sub.initialized {
+			uninitialized++
+		}
+	}
+	if uninitialized > 0 {
+		advice = append(advice, fmt.Sprintf("%d submodules are not checked out; use --submodules=recurse to include them.", uninitialized))
+	}
+	if r.sparse != nil && !sparseOnly {
+		advice = append(advice, "This is a sparse checkout; use --sparse to skip files outside the cone.")
+	}
+	if len(runWarnings) > 0 {
+		advice = append(advice, fmt.Sprintf("%d entries could not be read; see the warnings above.", len(runWarnings)))
+	}
+	return advice
+}
+
+func (r *doctorReport) write(w *strings.Builder) {
+	w.WriteString(fmt.Sprintf("Directory: %s\n", r.dir))
+	if r.topLevel != "" {
+		w.WriteString(fmt.Sprintf("- Git repository: %s\n", r.topLevel))
+	} else {
+		w.WriteString("- Git repository: none\n")
+	}
+	if r.sparse != nil {
+		w.WriteString("- Sparse checkout: yes\n")
+	}
+	if len(r.submodules) > 0 {
+		w.WriteString(fmt.Sprintf("- Submodules: %d\n", len(r.submodules)))
+	}
+	w.WriteString("- Ignore files:\n")
+	if len(r.ignoreFiles) == 0 {
+		w.WriteString("  (none)\n")
+	}
+	for _, file := range r.ignoreFiles {
+		w.WriteString(fmt.Sprintf("  %s\n", file))
+	}
+	w.WriteString(fmt.Sprintf("- Included: %d files, %s\n", r.files, formatBytes(r.size)))
+	if excluded := exclusionSummary(r.exclusions); excluded != "" {
+		w.WriteString(fmt.Sprintf("- Excluded: %s\n", excluded))
+	}
+	w.WriteString(fmt.Sprintf("- Estimated output: %s (~%d tokens)\n", formatBytes(r.estimate), r.estimate/doctorBytesPerToken))
+	if advice := r.advice(); len(advice) > 0 {
+		w.WriteString("\nAdvice:\n")
+		for _, line := range advice {
+			w.WriteString(fmt.Sprintf("- %s\n", line))
+		}
+	}
+}
+
+var doctorCmd = &cobra.Command{
+	Use:   "doctor [directory]",
+	Short: "Check a directory and estimate the output before running flatten",
+	Long: `Doctor checks that the directory is readable, whether it is a git
+repository, which ignore files apply and how large the output would be with
+the default filters, and suggests how to narrow an expensive run. File
+contents are not read.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dir := "."
+		if len(args) > 0 {
+			dir = args[0]
+		}
+		report, err := diagnose(dir)
+		if err != nil {
+			return err
+		}
+		var output strings.Builder
+		report.write(&output)
+		fmt.Print(output.String())
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(doctorCmd)
+}
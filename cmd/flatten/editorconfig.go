@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/fs"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+var useEditorConfig bool
+
+// editorConfigSection is a [glob] section of an .editorconfig file
+type editorConfigSection struct {
+	pattern    *regexp.Regexp
+	properties map[string]string
+}
+
+// editorConfig is a parsed .editorconfig file
+type editorConfig struct {
+	// root stops the search for .editorconfig files in parent directories
+	root     bool
+	sections []editorConfigSection
+}
+
+// editorConfigMatcher resolves the .editorconfig properties of files in a
+// walked file system, loading each directory's file once. Files above the
+// flattened directory aren't read.
+type editorConfigMatcher struct {
+	fsys fs.FS
+	dirs map[string]*editorConfig
+}
+
+func newEditorConfigMatcher(fsys fs.FS) *editorConfigMatcher {
+	return &editorConfigMatcher{fsys: fsys, dirs: make(map[string]*editorConfig)}
+}
+
+// configFor loads (and caches) the .editorconfig of dir, or returns nil
+func (m *editorConfigMatcher) configFor(dir string) *editorConfig {
+	if config, ok := m.dirs[dir]; ok {
+		return config
+	}
+	var config *editorConfig
+	if data, err := fs.ReadFile(m.fsys, path.Join(dir, ".editorconfig")); err == nil {
+		config = parseEditorConfig(string(data))
+	}
+	m.dirs[dir] = config
+	return config
+}
+
+// parseEditorConfig parses the INI-like .editorconfig format. Keys and the
+// values of the properties flatten uses are case-insensitive.
+func parseEditorConfig(data string) *editorConfig {
+	config := &editorConfig{}
+	var section *editorConfigSection
+	for _, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		if line[0] == '[' && line[len(line)-1] == ']' {
+			config.sections = append(config.sections, editorConfigSection{
+				pattern:    editorConfigGlob(line[1 : len(line)-1]),
+				properties: make(map[string]string),
+			})
+			section = &config.sections[len(config.sections)-1]
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.ToLower(strings.TrimSpace(value))
+		if section == nil {
+			config.root = config.root || key == "root" && value == "true"
+		} else {
+			section.properties[key] = value
+		}
+	}
+	return config
+}
+
+// editorConfigGlob translates a section glob into a regular expression
+// matched against paths relative to the .editorconfig's directory. Globs
+// without a slash match file names at any depth. Numeric ranges ({1..3})
+// aren't supported and match literally.
+func editorConfigGlob(glob string) *regexp.Regexp {
+	var sb strings.Builder
+	sb.WriteString("^")
+	if !strings.Contains(glob, "/") {
+		sb.WriteString("(?:.*/)?")
+	}
+	glob = strings.TrimPrefix(glob, "/")
+	braces := 0
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch {
+		case c == '\\' && i+1 < len(glob):
+			i++
+			sb.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		case c == '*' && i+1 < len(glob) && glob[i+1] == '*':
+			i++
+			sb.WriteString(".*")
+		case c == '*':
+			sb.WriteString("[^/]*")
+		case c == '?':
+			sb.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(glob[i:], ']')
+			if end < 0 {
+				sb.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			sb.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end
+		case c == '{':
+			braces++
+			sb.WriteString("(?:")
+		case c == '}' && braces > 0:
+			braces--
+			sb.WriteString(")")
+		case c == ',' && braces > 0:
+			sb.WriteString("|")
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	for ; braces > 0; braces-- {
+		sb.WriteString(")")
+	}
+	sb.WriteString("$")
+	re, err := regexp.Compile(sb.String())
+	if err != nil {
+		return nil
+	}
+	return re
+}
+
+// propertiesFor returns the properties that apply to name, a file of the
+// walked file system. Closer .editorconfig files and later sections win;
+// "unset" clears a property.
+func (m *editorConfigMatcher) propertiesFor(name string) map[string]string {
+	var dirs []string
+	for dir := path.Dir(name); ; dir = path.Dir(dir) {
+		config := m.configFor(dir)
+		if config != nil {
+			dirs = append(dirs, dir)
+			if config.root {
+				break
+			}
+		}
+		if dir == "." {
+			break
+		}
+	}
+	properties := make(map[string]string)
+	for i := len(dirs) - 1; i >= 0; i-- {
+		rel := name
+		if dirs[i] != "." {
+			rel = strings.TrimPrefix(name, dirs[i]+"/")
+		}
+		for _, section := range m.configFor(dirs[i]).sections {
+			if section.pattern == nil || !section.pattern.MatchString(rel) {
+				continue
+			}
+			for key, value := range section.properties {
+				if value == "unset" {
+					delete(properties, key)
+				} else {
+					properties[key] = value
+				}
+			}
+		}
+	}
+	return properties
+}
+
+// tabWidth returns the tab width the properties ask for, falling back to a
+// numeric indent_size as the specification does, or 0 when neither is set
+func tabWidth(properties map[string]string) int {
+	for _, key := range []string{"tab_width", "indent_size"} {
+		if width, err := strconv.Atoi(properties[key]); err == nil && width > 0 {
+			return width
+		}
+	}
+	return 0
+}
+
+// expandTabs replaces tabs with spaces up to the next multiple of width
+func expandTabs(content []byte, width int) []byte {
+	if !bytes.ContainsRune(content, '\t') {
+		return content
+	}
+	var out bytes.Buffer
+	column := 0
+	for len(content) > 0 {
+		r, size := utf8.DecodeRune(content)
+		content = content[size:]
+		switch r {
+		case '\t':
+			spaces := width - column%width
+			out.WriteString(strings.Repeat(" ", spaces))
+			column += spaces
+		case '\n':
+			out.WriteByte('\n')
+			column = 0
+		default:
+			out.WriteRune(r)
+			column++
+		}
+	}
+	return out.Bytes()
+}
+
+// decodeCharset converts content in an .editorconfig charset to UTF-8. It
+// reports false for charsets that need no conversion.
+func decodeCharset(content []byte, charset string) ([]byte, bool) {
+	switch charset {
+	case "latin1":
+		runes := make([]rune, len(content))
+		for i, b := range content {
+			runes[i] = rune(b)
+		}
+		return []byte(string(runes)), true
+	case "utf-8-bom":
+		return bytes.TrimPrefix(content, []byte("\xef\xbb\xbf")), true
+	case "utf-16be", "utf-16le":
+		var order binary.ByteOrder = binary.BigEndian
+		if charset == "utf-16le" {
+			order = binary.LittleEndian
+		}
+		units := make([]uint16, 0, len(content)/2)
+		for i := 0; i+1 < len(content); i += 2 {
+			units = append(units, order.Uint16(content[i:]))
+		}
+		if len(units) > 0 && units[0] == 0xfeff {
+			units = units[1:]
+		}
+		return []byte(string(utf16.Decode(units))), true
+	}
+	return content, false
+}
+
+// applyEditorConfig renders content the way the project's .editorconfig
+// describes it: decoded from its charset, with tabs expanded to its tab
+// width. It returns the charset it decoded from, or "".
+func applyEditorConfig(m *editorConfigMatcher, entry *FileEntry, content []byte) ([]byte, string) {
+	if m == nil {
+		return content, ""
+	}
+	properties := m.propertiesFor(entry.name)
+	charset := properties["charset"]
+	content, decoded := decodeCharset(content, charset)
+	if !decoded {
+		charset = ""
+	}
+	if width := tabWidth(properties); width > 0 {
+		content = expandTabs(content, width)
+	}
+	return content, charset
+}
@@ -0,0 +1,124 @@
+//go:build js && wasm
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"syscall/js"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+// execute exposes flatten to JavaScript as a global function instead of
+// running the command line interface:
+//
+//	flatten(files, flags)
+//
+// files maps slash-separated relative paths to their contents (a string or a
+// Uint8Array), e.g. as gathered from a dropped folder; flags is an optional
+// array of command-line flags such as ["--tree-only"]. It returns the
+// flattened output as a string, or an Error object on failure. Everything
+// runs client-side.
+func execute() {
+	js.Global().Set("flatten", js.FuncOf(func(this js.Value, args []js.Value) any {
+		if len(args) == 0 {
+			return js.Global().Get("Error").New("flatten: missing files argument")
+		}
+		var flags []string
+		if len(args) > 1 && !args[1].IsUndefined() {
+			for i := 0; i < args[1].Length(); i++ {
+				flags = append(flags, args[1].Index(i).String())
+			}
+		}
+		output, err := flattenJS(args[0], flags)
+		if err != nil {
+			return js.Global().Get("Error").New("flatten: " + err.Error())
+		}
+		return output
+	}))
+	select {}
+}
+
+// flattenJS flattens an in-memory file tree handed over from JavaScript
+func flattenJS(files js.Value, flags []string) (string, error) {
+	resetFlags(rootCmd.Flags())
+	runWarnings = nil
+	if err := rootCmd.Flags().Parse(flags); err != nil {
+		return "", err
+	}
+	if err := applyQuick(rootCmd.Flags()); err != nil {
+		return "", err
+	}
+	if err := validateOptions(); err != nil {
+		return "", err
+	}
+	if outputFormat == "fbin" {
+		return "", fmt.Errorf("--format fbin is not supported in the browser")
+	}
+
+	tree := make(map[string]*memFile)
+	now := time.Now()
+	keys := js.Global().Get("Object").Call("keys", files)
+	for i := 0; i < keys.Length(); i++ {
+		name := strings.TrimPrefix(keys.Index(i).String(), "/")
+		value := files.Get(keys.Index(i).String())
+		var data []byte
+		if value.Type() == js.TypeString {
+			data = []byte(value.String())
+		} else {
+			data = make([]byte, value.Get("length").Int())
+			js.CopyBytesToGo(data, value)
+		}
+		tree[name] = &memFile{data: data, mode: 0o644, modTime: now}
+	}
+
+	fsys := newMemFS(tree)
+
+	tokenizer, err := loadTokenizer()
+	if err != nil {
+		return "", err
+	}
+	filter := NewFSFilter(fsys, ".", filterOptions())
+	limits := &walkLimits{maxFiles: maxFiles, failOnMax: onMaxFiles != "stop"}
+	if quick {
+		limits.maxDepth = quickDepth
+	}
+	root, err := loadDirectory(&walkState{
+		fsys:      fsys,
+		root:      ".",
+		filter:    filter,
+		tokenizer: tokenizer,
+		limits:    limits,
+	}, ".")
+	if err != nil {
+		return "", fmt.Errorf("failed to load directory structure: %w", err)
+	}
+	var output strings.Builder
+	runOptions = usedOptions(rootCmd.Flags())
+	writeFormatHeader(&output)
+	if root != nil {
+		if sampleCount > 0 {
+			sampleTree(root, sampleCount, filter)
+		}
+		if err := renderRoot(&output, ".", root, filter, make(map[string]*FileHash)); err != nil {
+			return "", err
+		}
+	}
+	writeFormatWarnings(&output)
+	return output.String(), nil
+}
+
+// resetFlags restores every flag to its default so consecutive calls don't
+// inherit each other's settings
+func resetFlags(flags *pflag.FlagSet) {
+	flags.VisitAll(func(f *pflag.Flag) {
+		if sv, ok := f.Value.(pflag.SliceValue); ok {
+			sv.Replace(nil)
+		} else {
+			f.Value.Set(f.DefValue)
+		}
+		f.Changed = false
+	})
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+)
+
+// fbinVersion is bumped whenever the fbin record layout changes
+const fbinVersion = 1
+
+// fbinMagic opens every fbin stream and is followed by the version byte
+const fbinMagic = "FLATBIN"
+
+// fbin record types
+const (
+	fbinRoot    = 'R'
+	fbinDir     = 'D'
+	fbinFile    = 'F'
+	fbinWarning = 'W'
+)
+
+// writeFbinField writes a field as its uvarint length followed by its bytes
+func writeFbinField(w *strings.Builder, field []byte) {
+	var length [binary.MaxVarintLen64]byte
+	w.Write(length[:binary.PutUvarint(length[:], uint64(len(field)))])
+	w.Write(field)
+}
+
+func writeFbinRecord(w *strings.Builder, kind byte, fields ...string) {
+	w.WriteByte(kind)
+	for _, field := range fields {
+		writeFbinField(w, []byte(field))
+	}
+}
+
+// writeFbinHeader writes the magic and version that open an fbin stream
+func writeFbinHeader(w *strings.Builder) {
+	w.WriteString(fbinMagic)
+	w.WriteByte(fbinVersion)
+}
+
+// writeFbin writes one root as fbin records. fbin carries the same records as
+// porcelain, but every field is prefixed with its uvarint length instead of
+// being terminated by a separator:
+//
+//	'R' <dir>
+//	'W' <kind> <path> <message>
+//	'D' <path> <mode> <mtime> <children>
+//	'F' <path> <size> <mode> <mtime> <sha256> <duplicate-of> <link-target> <has-content> [<content>]
+//
+// has-content is a single byte, 1 when the content field follows and 0 when
+// it is omitted. 'D' records are only written with --dir-records. Contents are stored raw and nothing in the stream depends
+// on the position of a record, so an unchanged file is an identical byte run
+// from one snapshot to the next, which rsync and zstd --long can match.
+func writeFbin(w *strings.Builder, dir string, root *FileEntry, fileHashes map[string]*FileHash) error {
+	writeFbinRecord(w, fbinRoot, dir)
+	return eachRecord(root, fileHashes, func(dir *FileEntry) {
+		writeFbinRecord(w, fbinDir, dirFields(dir)...)
+	}, func(rec *fileRecord) {
+		writeFbinRecord(w, fbinFile, rec.fields()...)
+		if rec.omitted {
+			w.WriteByte(0)
+			return
+		}
+		w.WriteByte(1)
+		writeFbinField(w, rec.content)
+	})
+}
+
+// writeFbinWarnings writes a warning record for each warning of the run
+func writeFbinWarnings(w *strings.Builder) {
+	for _, warning := range runWarnings {
+		writeFbinRecord(w, fbinWarning, warning.Kind, warning.Path, warning.Message)
+	}
+}
+
+// fbinReader parses the stream written by --format fbin
+type fbinReader struct {
+	r    *bufio.Reader
+	root string
+}
+
+// newFbinReader checks the magic and version that open the stream
+func newFbinReader(r *bufio.Reader) (*fbinReader, error) {
+	head := make([]byte, len(fbinMagic)+1)
+	if _, err := io.ReadFull(r, head); err != nil || string(head[:len(fbinMagic)]) != fbinMagic {
+		return nil, fmt.Errorf("input is not flatten --format fbin output")
+	}
+	if version := head[len(fbinMagic)]; version < 1 || version > fbinVersion {
+		return nil, fmt.Errorf("unsupported fbin version %d", version)
+	}
+	return &fbinReader{r: r}, nil
+}
+
+func (fr *fbinReader) readField() ([]byte, error) {
+	length, err := binary.ReadUvarint(fr.r)
+	if err != nil || length > math.MaxInt64 {
+		return nil, io.ErrUnexpectedEOF
+	}
+	field, err := readContent(fr.r, int64(length))
+	if err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return field, nil
+}
+
+func (fr *fbinReader) readFields(n int) ([]string, error) {
+	fields := make([]string, n)
+	for i := range fields {
+		field, err := fr.readField()
+		if err != nil {
+			return nil, err
+		}
+		fields[i] = string(field)
+	}
+	return fields, nil
+}
+
+// next returns the next file or directory record, skipping the other record
+// types. It returns io.EOF at the end of the stream.
+func (fr *fbinReader) next() (*porcelainRecord, error) {
+	for {
+		kind, err := fr.r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		switch kind {
+		case fbinRoot:
+			fields, err := fr.readFields(1)
+			if err != nil {
+				return nil, err
+			}
+			fr.root = fields[0]
+		case fbinWarning:
+			if _, err := fr.readFields(3); err != nil {
+				return nil, err
+			}
+		case fbinDir:
+			fields, err := fr.readFields(4)
+			if err != nil {
+				return nil, err
+			}
+			return newDirRecord(fr.root, fields)
+		case fbinFile:
+			return fr.readFile()
+		default:
+			return nil, fmt.Errorf("unknown fbin record %q", kind)
+		}
+	}
+}
+
+func (fr *fbinReader) readFile() (*porcelainRecord, error) {
+	fields, err := fr.readFields(7)
+	if err != nil {
+		return nil, err
+	}
+	rec, err := newPorcelainRecord(fr.root, fields)
+	if err != nil {
+		return nil, err
+	}
+	hasContent, err := fr.r.ReadByte()
+	if err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+	switch hasContent {
+	case 0:
+		return rec, nil
+	case 1:
+		if rec.content, err = fr.readField(); err != nil {
+			return nil, fmt.Errorf("truncated content for %s: %w", rec.path, err)
+		}
+		return rec, nil
+	default:
+		return nil, fmt.Errorf("malformed content for %s", rec.path)
+	}
+}
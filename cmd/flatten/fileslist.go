@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// listedFile is a line of a --files-from list
+type listedFile struct {
+	// name is slash-separated and relative to the flattened directory
+	name string
+	// hash is the expected SHA-256 of the content, or ""
+	hash string
+}
+
+var (
+	filesFrom      string
+	onHashMismatch string
+)
+
+// readFileList parses a --files-from list: one path per line, optionally
+// followed by a tab and the SHA-256 the content must have. Blank lines are
+// ignored.
+func readFileList(r io.Reader, source string) ([]listedFile, error) {
+	var list []listedFile
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		name, hash, _ := strings.Cut(line, "\t")
+		name = path.Clean(filepath.ToSlash(name))
+		if !fs.ValidPath(name) || name == "." {
+			return nil, fmt.Errorf("%s:%d: %q must be a relative path inside the directory", source, lineNo, name)
+		}
+		hash = strings.ToLower(strings.TrimSpace(hash))
+		if hash != "" {
+			if decoded, err := hex.DecodeString(hash); err != nil || len(decoded) != 32 {
+				return nil, fmt.Errorf("%s:%d: invalid sha256 %q", source, lineNo, hash)
+			}
+		}
+		list = append(list, listedFile{name: name, hash: hash})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", source, err)
+	}
+	return list, nil
+}
+
+// openFileList reads the --files-from list, from stdin when it is "-"
+func openFileList() ([]listedFile, error) {
+	if filesFrom == "-" {
+		return readFileList(os.Stdin, "stdin")
+	}
+	file, err := os.Open(filesFrom)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open --files-from list: %w", err)
+	}
+	defer file.Close()
+	return readFileList(file, filesFrom)
+}
+
+// loadFileList builds the tree of the listed files. The list is the
+// selection, so the filters aren't applied; files listed twice are loaded
+// once. Symlinks along a listed path are checked as the walk checks them,
+// so --confine-to-root and --follow-symlinks leave out the files they lead
+// to.
+func loadFileList(w *walkState, list []listedFile) (*FileEntry, error) {
+	info, err := fs.Stat(w.fsys, ".")
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat path %s: %w", w.root, err)
+	}
+	if auditPerms {
+		w.auditEntry(".", w.root, info)
+	}
+	root, err := newFileEntry(w, ".", info, "")
+	if err != nil {
+		return nil, err
+	}
+	// dirs maps the directories loaded so far to their entries, and those
+	// left out because of a symlink to nil
+	dirs := map[string]*FileEntry{".": root}
+	var dirFor func(name string) (*FileEntry, error)
+	dirFor = func(name string) (*FileEntry, error) {
+		if dir, ok := dirs[name]; ok {
+			return dir, nil
+		}
+		parent, err := dirFor(path.Dir(name))
+		if err != nil || parent == nil {
+			return nil, err
+		}
+		linkTarget, reason := w.checkSymlink(filepath.Join(w.root, filepath.FromSlash(name)))
+		if reason != "" {
+			w.filter.CountExclusion(reason)
+			dirs[name] = nil
+			return nil, nil
+		}
+		info, err := fs.Stat(w.fsys, name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to stat path %s: %w", name, err)
+		}
+		if auditPerms {
+			w.auditEntry(name, filepath.Join(w.root, filepath.FromSlash(name)), info)
+		}
+		dir, err := newFileEntry(w, name, info, linkTarget)
+		if err != nil {
+			return nil, err
+		}
+		parent.Children = append(parent.Children, dir)
+		dirs[name] = dir
+		return dir, nil
+	}
+	seen := make(map[string]bool)
+	for _, listed := range list {
+		if seen[listed.name] {
+			continue
+		}
+		seen[listed.name] = true
+		parent, err := dirFor(path.Dir(listed.name))
+		if err != nil {
+			return nil, err
+		}
+		if parent == nil {
+			continue
+		}
+		linkTarget, reason := w.checkSymlink(filepath.Join(w.root, filepath.FromSlash(listed.name)))
+		if reason != "" {
+			w.filter.CountExclusion(reason)
+			continue
+		}
+		info, err := fs.Stat(w.fsys, listed.name)
+		if err != nil {
+			return nil, fmt.Errorf("listed file %s: %w", listed.name, err)
+		}
+		if info.IsDir() {
+			return nil, fmt.Errorf("listed file %s is a directory", listed.name)
+		}
+		if auditPerms {
+			w.auditEntry(listed.name, filepath.Join(w.root, filepath.FromSlash(listed.name)), info)
+		}
+		entry, err := newFileEntry(w, listed.name, info, linkTarget)
+		if err != nil {
+			return nil, err
+		}
+		if entry == nil {
+			break
+		}
+		parent.Children = append(parent.Children, entry)
+	}
+	sortTree(root)
+	root.findings = w.findings
+	return root, nil
+}
+
+// sortTree orders children by name, as a walk lists them
+func sortTree(entry *FileEntry) {
+	sort.Slice(entry.Children, func(i, j int) bool {
+		return path.Base(entry.Children[i].name) < path.Base(entry.Children[j].name)
+	})
+	for _, child := range entry.Children {
+		sortTree(child)
+	}
+}
+
+// verifyFileList checks the listed hashes against the loaded files. A
+// mismatch fails the run, or with --on-hash-mismatch warn is reported as a
+// warning; the file is then flattened as it is on disk.
+func verifyFileList(root *FileEntry, list []listedFile) error {
+	entries := make(map[string]*FileEntry)
+	var index func(entry *FileEntry)
+	index = func(entry *FileEntry) {
+		entries[entry.name] = entry
+		for _, child := range entry.Children {
+			index(child)
+		}
+	}
+	index(root)
+	for _, listed := range list {
+		entry, ok := entries[listed.name]
+		if listed.hash == "" || !ok {
+			continue
+		}
+		hash, err := entry.Hash()
+		if err != nil {
+			return err
+		}
+		if hash == listed.hash {
+			continue
+		}
+		if onHashMismatch == "fail" {
+			return fmt.Errorf("%s: sha256 is %s, but the list expects %s", entry.Path, hash, listed.hash)
+		}
+		warn(warnHashMismatch, entry.Path, "sha256 is %s, but the list expects %s", hash, listed.hash)
+	}
+	return nil
+}
@@ -1,18 +1,23 @@
 package main
 
 import (
+	"bytes"
+	"fmt"
+	"io/fs"
 	"mime"
 	"net/http"
 	"os"
+	"path"
 	"path/filepath"
+	"regexp"
 	"strings"
-
-	ignore "github.com/sabhiram/go-gitignore"
 )
 
 // Filter handles file filtering logic
 type Filter struct {
-	gitIgnore       *ignore.GitIgnore
+	fsys            fs.FS
+	gitIgnore       *gitIgnoreMatcher
+	flattenIgnore   *gitIgnoreMatcher
 	includeAll      bool
 	includeGit      bool
 	includeBin      bool
@@ -20,22 +25,105 @@ type Filter struct {
 	includePatterns []string
 	excludePatterns []string
 	excludedDirs    []string
+	contentPatterns []*regexp.Regexp
+	contentHead     int
+	// skippedSubmodules are kept as entries without their contents
+	skippedSubmodules []string
+	trackedFiles      map[string]bool
+	sparse            *sparseCone
+	sparseOnly        bool
+	gitDir            string
+	gitParts          []string
+	exclusions        map[string]int
+}
+
+// FilterOptions configures which files a Filter lets through
+type FilterOptions struct {
+	IncludeGitIgnore bool
+	IncludeGit       bool
+	GitParts         []string
+	IncludeBin       bool
+	UntrackedOnly    bool
+	SparseOnly       bool
+	SkipSubmodules   bool
+	IncludePatterns  []string
+	ExcludePatterns  []string
+	// ExcludeContent rejects files whose first ContentHead bytes match
+	ExcludeContent []*regexp.Regexp
+	ContentHead    int
 }
 
 // NewFilter creates a new filter for the given directory.
 // Exclude patterns ending with "/" are treated as directory excludes; otherwise, file excludes.
-func NewFilter(
-	dir string,
-	includeGitIgnore bool,
-	includeGit bool,
-	includeBin bool,
-	includePatterns []string,
-	excludePatterns []string,
-) (*Filter, error) {
+func NewFilter(dir string, opts FilterOptions) (*Filter, error) {
+	f := newFilter(os.DirFS(dir), dir, opts)
+
+	if opts.UntrackedOnly {
+		tracked, err := gitTrackedFiles(dir)
+		if err != nil {
+			return nil, fmt.Errorf("--untracked-only requires a git repository: %w", err)
+		}
+		f.trackedFiles = tracked
+	}
+
+	if opts.SkipSubmodules {
+		for _, sub := range gitSubmodules(dir) {
+			f.skippedSubmodules = append(f.skippedSubmodules, sub.path)
+		}
+	}
+
+	sparse, err := gitSparseCone(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sparse-checkout state: %w", err)
+	}
+	f.sparse = sparse
+
+	topLevel, prefix := dir, ""
+	if out, err := runGit(dir, "rev-parse", "--show-toplevel", "--show-prefix"); err == nil {
+		lines := strings.Split(string(out), "\n")
+		if len(lines) >= 2 {
+			topLevel, prefix = lines[0], lines[1]
+		}
+	}
+
+	gitDir, commonDir, err := resolveGitDir(topLevel)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve git directory: %w", err)
+	}
+	f.gitDir = gitDir
+
+	if !opts.IncludeGitIgnore {
+		var global []*ignoreRules
+		if commonDir != "" {
+			if file := globalExcludesFile(topLevel); file != "" {
+				global = append(global, readIgnoreFile(file, ""))
+			}
+			// info/exclude is shared by all worktrees, so it lives in the common dir
+			global = append(global, readIgnoreFile(filepath.Join(commonDir, "info", "exclude"), ""))
+		}
+		f.gitIgnore = newGitIgnoreMatcher(os.DirFS(topLevel), prefix, ".gitignore", global)
+	}
+
+	return f, nil
+}
+
+// NewFSFilter creates a filter for a directory tree served by fsys, labelled
+// dir in paths. Only the .gitignore files inside fsys are honored; git state
+// such as sparse checkouts and tracked files isn't available.
+func NewFSFilter(fsys fs.FS, dir string, opts FilterOptions) *Filter {
+	f := newFilter(fsys, dir, opts)
+	if !opts.IncludeGitIgnore {
+		f.gitIgnore = newGitIgnoreMatcher(fsys, "", ".gitignore", nil)
+	}
+	return f
+}
+
+// newFilter sets up the pattern-based parts shared by all filters
+func newFilter(fsys fs.FS, dir string, opts FilterOptions) *Filter {
 	var excludedDirs []string
 	var fileExcludePatterns []string
 
-	for _, pat := range excludePatterns {
+	for _, pat := range opts.ExcludePatterns {
 		if strings.HasSuffix(pat, "/") {
 			cleaned := strings.TrimSuffix(pat, "/")
 			excludedDirs = append(excludedDirs, cleaned)
@@ -44,77 +132,234 @@ func NewFilter(
 		}
 	}
 
-	f := &Filter{
-		includeAll:      includeGitIgnore,
-		includeGit:      includeGit,
-		includeBin:      includeBin,
+	return &Filter{
+		fsys:            fsys,
+		flattenIgnore:   newGitIgnoreMatcher(fsys, "", ".flattenignore", nil),
+		includeAll:      opts.IncludeGitIgnore,
+		includeGit:      opts.IncludeGit,
+		gitParts:        opts.GitParts,
+		includeBin:      opts.IncludeBin,
 		baseDir:         dir,
-		includePatterns: includePatterns,
+		includePatterns: opts.IncludePatterns,
 		excludePatterns: fileExcludePatterns,
 		excludedDirs:    excludedDirs,
+		sparseOnly:      opts.SparseOnly,
+		contentPatterns: opts.ExcludeContent,
+		contentHead:     opts.ContentHead,
 	}
+}
 
-	if !includeGitIgnore {
-		gitIgnorePath := filepath.Join(dir, ".gitignore")
-		if _, err := os.Stat(gitIgnorePath); err == nil {
-			gitIgnore, err := ignore.CompileIgnoreFile(gitIgnorePath)
-			if err != nil {
-				return nil, err
-			}
-			f.gitIgnore = gitIgnore
+// wellKnownFiles are the extensionless files --ext keeps alongside the
+// requested extensions, as they matter whatever the language
+var wellKnownFiles = []string{
+	"Makefile", "GNUmakefile", "makefile", "Dockerfile", "Dockerfile.*",
+	"Containerfile", "Jenkinsfile", "Vagrantfile", "Procfile", "Justfile",
+	"Gemfile", "Rakefile",
+}
+
+// extensionPatterns turns --ext values such as "go" or ".md" into include
+// patterns
+func extensionPatterns(exts []string) []string {
+	if len(exts) == 0 {
+		return nil
+	}
+	patterns := append([]string{}, wellKnownFiles...)
+	for _, ext := range exts {
+		ext = strings.TrimPrefix(strings.TrimPrefix(strings.TrimSpace(ext), "*"), ".")
+		if ext != "" {
+			patterns = append(patterns, "*."+ext)
 		}
 	}
+	return patterns
+}
 
-	return f, nil
+// Exclusion reasons, in the order the filters are applied
+const (
+	ReasonGitIgnore      = "gitignore"
+	ReasonFlattenIgnore  = "flattenignore"
+	ReasonExcludedDir    = "excluded dir"
+	ReasonGit            = ".git"
+	ReasonTracked        = "tracked"
+	ReasonSparse         = "sparse-checkout"
+	ReasonBinary         = "binary"
+	ReasonExcludePattern = "exclude pattern"
+	ReasonIncludePattern = "include pattern"
+	ReasonContent        = "content"
+	ReasonSymlink        = "symlink"
+	ReasonOutsideRoot    = "outside root"
+	ReasonEmpty          = "empty"
+	ReasonSampled        = "sampled"
+)
+
+// exclusionReasons lists every reason in filter order, for stable reporting
+var exclusionReasons = []string{
+	ReasonGitIgnore,
+	ReasonFlattenIgnore,
+	ReasonExcludedDir,
+	ReasonGit,
+	ReasonTracked,
+	ReasonSparse,
+	ReasonBinary,
+	ReasonExcludePattern,
+	ReasonIncludePattern,
+	ReasonContent,
+	ReasonSymlink,
+	ReasonOutsideRoot,
+	ReasonEmpty,
+	ReasonSampled,
+}
+
+// ShouldInclude returns true if the file/directory should be included.
+// Excluded entries are tallied by reason; see Exclusions.
+func (f *Filter) ShouldInclude(info fs.FileInfo, path string) bool {
+	reason := f.ExclusionReason(info, path)
+	if reason == "" {
+		return true
+	}
+	f.CountExclusion(reason)
+	return false
+}
+
+// CountExclusion tallies an entry excluded for reason, including by checks
+// made outside the filter
+func (f *Filter) CountExclusion(reason string) {
+	if f.exclusions == nil {
+		f.exclusions = make(map[string]int)
+	}
+	f.exclusions[reason]++
+}
+
+// Exclusions returns how many entries were excluded for each reason. An
+// excluded directory counts once, since its contents are never visited.
+func (f *Filter) Exclusions() map[string]int {
+	return f.exclusions
 }
 
-// ShouldInclude returns true if the file/directory should be included
-func (f *Filter) ShouldInclude(info os.FileInfo, path string) bool {
+// ExclusionReason returns why the file/directory is excluded, or "" if it
+// should be included
+func (f *Filter) ExclusionReason(info fs.FileInfo, path string) string {
+	if reason := f.PathExclusionReason(path, info.IsDir()); reason != "" {
+		return reason
+	}
+	if !info.IsDir() {
+		return f.FileExclusionReason(path)
+	}
+	return ""
+}
+
+// PathExclusionReason applies the filters that only need an entry's path and
+// type, so that walks can prune entries without stat'ing them
+func (f *Filter) PathExclusionReason(path string, isDir bool) string {
 	// If not includeAll (--include-gitignore), check gitignore first
 	if !f.includeAll && f.gitIgnore != nil {
 		relPath, err := filepath.Rel(f.baseDir, path)
-		if err == nil {
-			relPath = filepath.ToSlash(relPath)
-			if f.gitIgnore.MatchesPath(relPath) {
-				return false
+		if err == nil && relPath != "." {
+			if f.gitIgnore.Ignored(filepath.ToSlash(relPath), isDir) {
+				return ReasonGitIgnore
 			}
 		}
 	}
 
+	// .flattenignore files apply even with --include-gitignore
+	if relPath, err := filepath.Rel(f.baseDir, path); err == nil && relPath != "." {
+		if f.flattenIgnore.Ignored(filepath.ToSlash(relPath), isDir) {
+			return ReasonFlattenIgnore
+		}
+	}
+
 	// Check excluded directories
-	if info.IsDir() && f.isExcludedDir(path) {
-		return false
+	if isDir && f.isExcludedDir(path) {
+		return ReasonExcludedDir
 	}
 
-	// Check .git directory exclusion
-	if !f.includeGit {
-		base := filepath.Base(path)
-		if base == ".git" || strings.Contains(filepath.ToSlash(path), "/.git/") {
-			return false
-		}
+	// Check .git exclusion; in worktrees .git is a gitdir pointer file
+	if !f.includeGit && f.isGitPath(path) {
+		return ReasonGit
+	}
+	// With --git-parts, only the selected parts of .git are included
+	if f.includeGit && f.outsideGitParts(path) {
+		return ReasonGit
 	}
+	return ""
+}
 
-	if !info.IsDir() {
-		// Check binary exclusion
-		if !f.includeBin {
-			isBinary, err := f.isBinaryFile(path)
-			if err == nil && isBinary {
-				return false
-			}
-		}
+// FileExclusionReason applies the filters specific to files, which follow
+// the ones of PathExclusionReason
+func (f *Filter) FileExclusionReason(path string) string {
+	// Check untracked-only mode
+	if f.trackedFiles != nil && f.isTracked(path) {
+		return ReasonTracked
+	}
 
-		// Check explicit exclude patterns
-		if f.matchesAnyPattern(path, f.excludePatterns) {
-			return false
+	// Check sparse-checkout cone
+	if f.sparseOnly && f.isOutsideSparse(path) {
+		return ReasonSparse
+	}
+
+	// Check binary exclusion
+	if !f.includeBin {
+		isBinary, err := f.isBinaryFile(path)
+		if err == nil && isBinary {
+			return ReasonBinary
 		}
+	}
+
+	// Check explicit exclude patterns
+	if f.matchesAnyPattern(path, f.excludePatterns) {
+		return ReasonExcludePattern
+	}
+
+	// If include patterns exist, file must match at least one
+	if len(f.includePatterns) > 0 && !f.matchesAnyPattern(path, f.includePatterns) {
+		return ReasonIncludePattern
+	}
 
-		// If include patterns exist, file must match at least one
-		if len(f.includePatterns) > 0 {
-			return f.matchesAnyPattern(path, f.includePatterns)
+	// Content patterns come last, as they read the file
+	if len(f.contentPatterns) > 0 {
+		matches, err := f.matchesContent(path)
+		if err == nil && matches {
+			return ReasonContent
 		}
 	}
+	return ""
+}
+
+// isGitPath reports whether path is a .git entry, lies inside one, or lies
+// inside the resolved git directory of the base dir
+func (f *Filter) isGitPath(path string) bool {
+	base := filepath.Base(path)
+	if base == ".git" || strings.Contains(filepath.ToSlash(path), "/.git/") {
+		return true
+	}
+	if f.gitDir == "" {
+		return false
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	gitDir, err := filepath.Abs(f.gitDir)
+	if err != nil {
+		return false
+	}
+	return abs == gitDir || strings.HasPrefix(abs, gitDir+string(filepath.Separator))
+}
 
-	return true
+// IsSkippedSubmodule reports whether path is a submodule left out with
+// --submodules=skip. The walk keeps its directory, so the tree can show the
+// submodule, but doesn't descend into it.
+func (f *Filter) IsSkippedSubmodule(path string) bool {
+	rel, err := filepath.Rel(f.baseDir, path)
+	if err != nil {
+		return false
+	}
+	rel = filepath.ToSlash(rel)
+	for _, dir := range f.skippedSubmodules {
+		if rel == dir {
+			return true
+		}
+	}
+	return false
 }
 
 func (f *Filter) isExcludedDir(path string) bool {
@@ -132,21 +377,79 @@ func (f *Filter) isExcludedDir(path string) bool {
 	return false
 }
 
+// isTracked reports whether git knows about the file at path
+func (f *Filter) isTracked(path string) bool {
+	rel, err := filepath.Rel(f.baseDir, path)
+	if err != nil {
+		return false
+	}
+	return f.trackedFiles[filepath.ToSlash(rel)]
+}
+
+// isOutsideSparse reports whether the file lies outside the sparse-checkout cone
+func (f *Filter) isOutsideSparse(path string) bool {
+	if f.sparse == nil {
+		return false
+	}
+	rel, err := filepath.Rel(f.baseDir, path)
+	if err != nil {
+		return false
+	}
+	return !f.sparse.Contains(filepath.ToSlash(rel))
+}
+
+// MaterializedOutsideSparse counts tracked files outside the sparse-checkout
+// cone that are nonetheless present on disk
+func (f *Filter) MaterializedOutsideSparse() int {
+	if f.sparse == nil {
+		return 0
+	}
+	tracked, err := gitTrackedFiles(f.baseDir)
+	if err != nil {
+		return 0
+	}
+	count := 0
+	for rel := range tracked {
+		if f.sparse.Contains(rel) {
+			continue
+		}
+		if _, err := os.Lstat(filepath.Join(f.baseDir, filepath.FromSlash(rel))); err == nil {
+			count++
+		}
+	}
+	return count
+}
+
 // isBinaryFile attempts a quick detection of whether the file is binary or text
 func (f *Filter) isBinaryFile(path string) (bool, error) {
-	file, err := os.Open(path)
+	rel, err := filepath.Rel(f.baseDir, path)
+	if err != nil {
+		return false, err
+	}
+	file, err := f.fsys.Open(filepath.ToSlash(rel))
 	if err != nil {
 		return false, err
 	}
 	defer file.Close()
 
-	buffer := make([]byte, 2048)
+	buffer := make([]byte, sniffLen)
 	n, err := file.Read(buffer)
 	if err != nil {
 		return false, err
 	}
 	buffer = buffer[:n]
 
+	// A known signature settles it; this catches binaries with misleading
+	// or missing extensions
+	if mimeType := detectMagic(buffer); mimeType != "" {
+		return !isTextMime(mimeType), nil
+	}
+	// A shebang makes a script, even where the extension's MIME type,
+	// such as application/x-sh, would count as binary
+	if bytes.HasPrefix(buffer, []byte("#!")) {
+		return false, nil
+	}
+
 	mimeType := mime.TypeByExtension(filepath.Ext(path))
 	if strings.Contains(mimeType, "application/") &&
 		!strings.Contains(mimeType, "json") &&
@@ -162,11 +465,27 @@ func (f *Filter) matchesAnyPattern(path string, patterns []string) bool {
 	if len(patterns) == 0 {
 		return false
 	}
+	rel, err := filepath.Rel(f.baseDir, path)
+	if err != nil {
+		rel = filepath.Base(path)
+	}
 	for _, pattern := range patterns {
-		matched, err := filepath.Match(pattern, filepath.Base(path))
-		if err == nil && matched {
+		if matchPattern(pattern, filepath.ToSlash(rel)) {
 			return true
 		}
 	}
 	return false
 }
+
+// matchPattern matches an include or exclude pattern against rel, a
+// slash-separated path relative to the flattened directory. Patterns match
+// the file name, unless they start with a slash, which anchors them to the
+// flattened directory so they match that one path.
+func matchPattern(pattern, rel string) bool {
+	name := path.Base(rel)
+	if anchored, ok := strings.CutPrefix(pattern, "/"); ok {
+		pattern, name = anchored, rel
+	}
+	matched, err := path.Match(pattern, name)
+	return err == nil && matched
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// outputFormats lists the values of --format
+var outputFormats = []string{"markdown", "porcelain", "fbin"}
+
+// resolveFormat checks the --format value and reconciles it with
+// --porcelain, which is shorthand for --format porcelain
+func resolveFormat() error {
+	valid := false
+	for _, format := range outputFormats {
+		valid = valid || outputFormat == format
+	}
+	if !valid {
+		return fmt.Errorf("invalid --format value %q (valid: %s)", outputFormat, strings.Join(outputFormats, ", "))
+	}
+	if porcelain {
+		if outputFormat != "markdown" && outputFormat != "porcelain" {
+			return fmt.Errorf("--porcelain and --format %s cannot be used together", outputFormat)
+		}
+		outputFormat = "porcelain"
+	}
+	porcelain = outputFormat == "porcelain"
+	if dirRecords && outputFormat == "markdown" {
+		return fmt.Errorf("--dir-records requires --porcelain or --format fbin")
+	}
+	return nil
+}
+
+// writeFormatHeader writes whatever opens a stream: the stamp of the
+// human-readable output, or the header of the structured formats
+func writeFormatHeader(w *strings.Builder) {
+	switch outputFormat {
+	case "porcelain":
+		writePorcelainHeader(w)
+	case "fbin":
+		writeFbinHeader(w)
+	default:
+		if !noHeader && !listOnly {
+			writeStamp(w)
+		}
+	}
+}
+
+// writeFormatWarnings writes the warnings of the run in the output format.
+// A --list-only list has no room for them; they were echoed to stderr.
+func writeFormatWarnings(w *strings.Builder) {
+	if listOnly {
+		return
+	}
+	switch outputFormat {
+	case "porcelain":
+		writePorcelainWarnings(w)
+	case "fbin":
+		writeFbinWarnings(w)
+	default:
+		writeWarnings(w)
+	}
+}
+
+// writePathList writes the path of every file below entry, terminated by a
+// newline or, with --null, by NUL for paths that contain newlines
+func writePathList(w *strings.Builder, entry *FileEntry) {
+	if !entry.IsDir {
+		w.WriteString(entry.Path)
+		if nullSeparated {
+			w.WriteByte(0)
+		} else {
+			w.WriteByte('\n')
+		}
+		return
+	}
+	for _, child := range entry.Children {
+		writePathList(w, child)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// freshnessBucket counts the files modified less than age ago, and not in
+// an earlier bucket
+type freshnessBucket struct {
+	name string
+	age  time.Duration
+}
+
+var freshnessBuckets = []freshnessBucket{
+	{"last day", 24 * time.Hour},
+	{"last week", 7 * 24 * time.Hour},
+	{"last month", 30 * 24 * time.Hour},
+}
+
+var showFreshness bool
+
+// countFreshness sorts the files below entry into the freshness buckets,
+// with one more count for older files
+func countFreshness(entry *FileEntry, now time.Time, counts []int) {
+	if !entry.IsDir {
+		age := now.Sub(time.Unix(entry.ModTime, 0))
+		for i, bucket := range freshnessBuckets {
+			if age < bucket.age {
+				counts[i]++
+				return
+			}
+		}
+		counts[len(freshnessBuckets)]++
+		return
+	}
+	for _, child := range entry.Children {
+		countFreshness(child, now, counts)
+	}
+}
+
+// freshnessSummary renders the histogram as
+// "3 last day, 10 last week, 25 last month, 120 older"
+func freshnessSummary(root *FileEntry, now time.Time) string {
+	counts := make([]int, len(freshnessBuckets)+1)
+	countFreshness(root, now, counts)
+	parts := make([]string, 0, len(counts))
+	for i, bucket := range freshnessBuckets {
+		parts = append(parts, fmt.Sprintf("%d %s", counts[i], bucket.name))
+	}
+	parts = append(parts, fmt.Sprintf("%d older", counts[len(freshnessBuckets)]))
+	return strings.Join(parts, ", ")
+}
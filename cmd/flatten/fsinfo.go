@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// fsInfo describes the file system a flattened directory lives on
+type fsInfo struct {
+	fsType     string
+	mountPoint string
+	free       uint64
+	total      uint64
+}
+
+var showFSInfo bool
+
+func (i *fsInfo) String() string {
+	return fmt.Sprintf("%s on %s (%s free of %s)", i.fsType, i.mountPoint, formatBytes(int64(i.free)), formatBytes(int64(i.total)))
+}
+
+// unescapeMount decodes the octal escapes (\040 for a space) that mount
+// tables use in paths
+func unescapeMount(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			var c byte
+			if _, err := fmt.Sscanf(s[i+1:i+4], "%03o", &c); err == nil {
+				sb.WriteByte(c)
+				i += 3
+				continue
+			}
+		}
+		sb.WriteByte(s[i])
+	}
+	return sb.String()
+}
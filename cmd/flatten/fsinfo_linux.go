@@ -0,0 +1,60 @@
+//go:build linux
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+)
+
+// statFS describes the file system of real, a resolved absolute path: its
+// type and mount point come from the mount table, the space from statfs.
+// Free space is what an unprivileged user can still use.
+func statFS(real string) (*fsInfo, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(real, &st); err != nil {
+		return nil, err
+	}
+	info := &fsInfo{
+		free:  st.Bavail * uint64(st.Bsize),
+		total: st.Blocks * uint64(st.Bsize),
+	}
+	file, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	// Lines look like "36 35 98:0 /root /mnt rw,noatime - ext3 /dev/root rw";
+	// the mount point containing dir is the longest prefix, and of equal
+	// ones the last, which shadows the others
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		sep := -1
+		for i, field := range fields {
+			if field == "-" {
+				sep = i
+				break
+			}
+		}
+		if sep < 5 || sep+1 >= len(fields) {
+			continue
+		}
+		mountPoint := unescapeMount(fields[4])
+		if !(mountPoint == "/" || isWithin(real, mountPoint)) || len(mountPoint) < len(info.mountPoint) {
+			continue
+		}
+		info.mountPoint = mountPoint
+		info.fsType = fields[sep+1]
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if info.mountPoint == "" {
+		return nil, fmt.Errorf("no mount point found for %s", real)
+	}
+	return info, nil
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package main
+
+import "fmt"
+
+// statFS describes the file system of real; mount tables are only read on
+// Linux
+func statFS(real string) (*fsInfo, error) {
+	return nil, fmt.Errorf("--fs-info is only supported on Linux")
+}
@@ -0,0 +1,37 @@
+//go:build !windows
+
+package main
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+// statOwner returns the owner ID recorded in info, when its file system has
+// one
+func statOwner(info fs.FileInfo) (uint32, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+	return stat.Uid, true
+}
+
+// statIdentity returns the device and inode recorded in info, when its file
+// system has them
+func statIdentity(info fs.FileInfo) (dev, ino uint64, ok bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, false
+	}
+	return uint64(stat.Dev), uint64(stat.Ino), true
+}
+
+// diskUsage returns the space allocated to a file, counted in the 512-byte
+// blocks of st_blocks whatever the file system's block size
+func diskUsage(info fs.FileInfo) int64 {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return int64(stat.Blocks) * 512
+	}
+	return 0
+}
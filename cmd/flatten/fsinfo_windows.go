@@ -0,0 +1,23 @@
+//go:build windows
+
+package main
+
+import "io/fs"
+
+// statOwner returns the owner ID recorded in info; Windows file systems
+// have none
+func statOwner(info fs.FileInfo) (uint32, bool) {
+	return 0, false
+}
+
+// statIdentity returns the device and inode recorded in info; Windows file
+// info has neither
+func statIdentity(info fs.FileInfo) (dev, ino uint64, ok bool) {
+	return 0, 0, false
+}
+
+// diskUsage returns the space allocated to a file; Windows file info
+// doesn't record it
+func diskUsage(info fs.FileInfo) int64 {
+	return 0
+}
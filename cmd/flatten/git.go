@@ -0,0 +1,333 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// runGit runs a git command inside dir and returns its stdout
+func runGit(dir string, args ...string) ([]byte, error) {
+	return runGitInput(dir, nil, args...)
+}
+
+// runGitInput is runGit with input fed to the command's stdin
+func runGitInput(dir string, input []byte, args ...string) ([]byte, error) {
+	cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+	if input != nil {
+		cmd.Stdin = bytes.NewReader(input)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			return nil, fmt.Errorf("git %s: %w", strings.Join(args, " "), err)
+		}
+		return nil, fmt.Errorf("git %s: %s", strings.Join(args, " "), msg)
+	}
+	return out, nil
+}
+
+// splitNul splits NUL-terminated git output into its records
+func splitNul(out []byte) []string {
+	var records []string
+	for _, rec := range bytes.Split(out, []byte{0}) {
+		if len(rec) > 0 {
+			records = append(records, string(rec))
+		}
+	}
+	return records
+}
+
+// gitTrackedFiles returns the set of paths, relative to dir, that git tracks
+func gitTrackedFiles(dir string) (map[string]bool, error) {
+	out, err := runGit(dir, "ls-files", "-z")
+	if err != nil {
+		return nil, err
+	}
+	tracked := make(map[string]bool)
+	for _, path := range splitNul(out) {
+		tracked[path] = true
+	}
+	return tracked, nil
+}
+
+// gitHeadBlobs maps the paths, relative to dir, of the files below dir in
+// HEAD to their blob hashes. Submodules map to "".
+func gitHeadBlobs(dir string) (map[string]string, error) {
+	out, err := runGit(dir, "ls-tree", "-r", "-z", "HEAD")
+	if err != nil {
+		return nil, err
+	}
+	blobs := make(map[string]string)
+	for _, rec := range splitNul(out) {
+		// Records look like "<mode> <type> <hash>\t<path>"
+		meta, path, ok := strings.Cut(rec, "\t")
+		fields := strings.Fields(meta)
+		if !ok || len(fields) != 3 {
+			continue
+		}
+		switch fields[1] {
+		case "blob":
+			blobs[path] = fields[2]
+		case "commit":
+			blobs[path] = ""
+		}
+	}
+	return blobs, nil
+}
+
+// gitHashObjects returns the blob hashes git would store for files, applying
+// the repository's clean filters and line-ending conversion
+func gitHashObjects(dir string, files []string) ([]string, error) {
+	if len(files) == 0 {
+		return nil, nil
+	}
+	out, err := runGitInput(dir, []byte(strings.Join(files, "\n")+"\n"), "hash-object", "--stdin-paths")
+	if err != nil {
+		return nil, err
+	}
+	hashes := strings.Fields(string(out))
+	if len(hashes) != len(files) {
+		return nil, fmt.Errorf("git hash-object returned %d hashes for %d files", len(hashes), len(files))
+	}
+	return hashes, nil
+}
+
+// sparseCone describes the paths selected by a git sparse checkout
+type sparseCone struct {
+	prefix   string
+	coneMode bool
+	dirs     []string
+	patterns *ignoreRules
+}
+
+// gitSparseCone reads the sparse-checkout definition of the repository
+// containing dir. It returns nil when sparse checkout isn't in use.
+func gitSparseCone(dir string) (*sparseCone, error) {
+	out, err := runGit(dir, "config", "--bool", "core.sparseCheckout")
+	if err != nil || strings.TrimSpace(string(out)) != "true" {
+		return nil, nil
+	}
+	prefix, err := runGit(dir, "rev-parse", "--show-prefix")
+	if err != nil {
+		return nil, err
+	}
+	list, err := runGit(dir, "sparse-checkout", "list")
+	if err != nil {
+		return nil, err
+	}
+	cone := &sparseCone{prefix: strings.TrimSpace(string(prefix))}
+	out, _ = runGit(dir, "config", "--bool", "core.sparseCheckoutCone")
+	cone.coneMode = strings.TrimSpace(string(out)) == "true"
+
+	lines := strings.Split(strings.TrimSpace(string(list)), "\n")
+	if cone.coneMode {
+		for _, line := range lines {
+			if line = strings.Trim(strings.TrimSpace(line), "/"); line != "" {
+				cone.dirs = append(cone.dirs, line)
+			}
+		}
+	} else {
+		cone.patterns = parseIgnoreLines("", lines)
+	}
+	return cone, nil
+}
+
+// Contains reports whether the path, relative to the flattened directory,
+// lies inside the sparse-checkout cone
+func (c *sparseCone) Contains(rel string) bool {
+	full := c.prefix + rel
+	if !c.coneMode {
+		// Like git, the path itself decides first; when no pattern matches
+		// it, its closest directory that a pattern matches decides, so
+		// directory patterns such as "/src/" select the files below them
+		for name, isDir := full, false; name != "."; name, isDir = path.Dir(name), true {
+			if matched, selected := c.patterns.match(name, isDir); matched {
+				return selected
+			}
+		}
+		return false
+	}
+	parent := path.Dir(full)
+	// Files at the top level are always part of the cone
+	if parent == "." {
+		return true
+	}
+	for _, dir := range c.dirs {
+		// Inside a selected directory, or directly inside one of its ancestors
+		if full == dir || strings.HasPrefix(full, dir+"/") || strings.HasPrefix(dir, parent+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveGitDir locates the git directory for a working tree rooted at dir.
+// In linked worktrees and submodules ".git" is a file holding a "gitdir:"
+// pointer rather than a directory. The common directory is where shared state
+// such as info/exclude lives; it equals gitDir outside of linked worktrees.
+// Both results are empty when dir has no ".git" entry.
+func resolveGitDir(dir string) (gitDir string, commonDir string, err error) {
+	dotGit := filepath.Join(dir, ".git")
+	info, err := os.Stat(dotGit)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", "", nil
+		}
+		return "", "", err
+	}
+	gitDir = dotGit
+	if !info.IsDir() {
+		data, err := os.ReadFile(dotGit)
+		if err != nil {
+			return "", "", err
+		}
+		line := strings.TrimSpace(string(data))
+		if !strings.HasPrefix(line, "gitdir:") {
+			return "", "", fmt.Errorf("%s is not a valid gitdir pointer", dotGit)
+		}
+		gitDir = strings.TrimSpace(strings.TrimPrefix(line, "gitdir:"))
+		if !filepath.IsAbs(gitDir) {
+			gitDir = filepath.Join(dir, gitDir)
+		}
+	}
+	commonDir = gitDir
+	if data, err := os.ReadFile(filepath.Join(gitDir, "commondir")); err == nil {
+		commonDir = strings.TrimSpace(string(data))
+		if !filepath.IsAbs(commonDir) {
+			commonDir = filepath.Join(gitDir, commonDir)
+		}
+	}
+	return filepath.Clean(gitDir), filepath.Clean(commonDir), nil
+}
+
+// globalExcludesFile returns the user's core.excludesFile, falling back to
+// git's default location under the XDG config directory
+func globalExcludesFile(dir string) string {
+	if out, err := runGit(dir, "config", "--path", "core.excludesFile"); err == nil {
+		if file := strings.TrimSpace(string(out)); file != "" {
+			return file
+		}
+	}
+	if xdg := os.Getenv("XDG_CONFIG_HOME"); xdg != "" {
+		return filepath.Join(xdg, "git", "ignore")
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		return filepath.Join(home, ".config", "git", "ignore")
+	}
+	return ""
+}
+
+// submodule is a submodule recorded in the git index
+type submodule struct {
+	// path is relative to the directory git was run in
+	path        string
+	commit      string
+	initialized bool
+}
+
+// hasGitmodules reports whether the repository around dir declares
+// submodules, looking for .gitmodules up to the top of the work tree
+// without running git
+func hasGitmodules(dir string) bool {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return false
+	}
+	for {
+		if _, err := os.Stat(filepath.Join(abs, ".gitmodules")); err == nil {
+			return true
+		}
+		if _, err := os.Lstat(filepath.Join(abs, ".git")); err == nil {
+			return false
+		}
+		parent := filepath.Dir(abs)
+		if parent == abs {
+			return false
+		}
+		abs = parent
+	}
+}
+
+// gitSubmodules lists the submodules below dir from the gitlinks (mode
+// 160000) of the index. It returns nil outside of a repository with
+// submodules.
+func gitSubmodules(dir string) []submodule {
+	if !hasGitmodules(dir) {
+		return nil
+	}
+	out, err := runGit(dir, "ls-files", "-s", "-z")
+	if err != nil {
+		return nil
+	}
+	var subs []submodule
+	for _, record := range strings.Split(string(out), "\x00") {
+		// Records look like "<mode> <sha> <stage>\t<path>"
+		info, name, ok := strings.Cut(record, "\t")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(info)
+		if len(fields) != 3 || fields[0] != "160000" {
+			continue
+		}
+		// A checked-out submodule has a .git file or directory of its own
+		_, err := os.Lstat(filepath.Join(dir, filepath.FromSlash(name), ".git"))
+		subs = append(subs, submodule{
+			path:        name,
+			commit:      fields[1],
+			initialized: err == nil,
+		})
+	}
+	return subs
+}
+
+// submoduleShaLen is how much of a submodule's commit the tree shows
+const submoduleShaLen = 12
+
+// annotateSubmodules records the commit of each submodule below root, loaded
+// from dir, on its directory entry, including submodules that aren't
+// initialized and so have no content
+func annotateSubmodules(dir string, root *FileEntry) {
+	subs := gitSubmodules(dir)
+	if len(subs) == 0 {
+		return
+	}
+	commits := make(map[string]string, len(subs))
+	for _, sub := range subs {
+		commits[sub.path] = sub.commit
+	}
+	var walk func(entry *FileEntry)
+	walk = func(entry *FileEntry) {
+		if !entry.IsDir {
+			return
+		}
+		if commit, ok := commits[entry.name]; ok {
+			entry.Submodule = commit
+		}
+		for _, child := range entry.Children {
+			walk(child)
+		}
+	}
+	walk(root)
+}
+
+// initSubmodules shallowly fetches and checks out the uninitialized
+// submodules below dir, so they can be flattened like the rest of the tree
+func initSubmodules(dir string) {
+	for _, sub := range gitSubmodules(dir) {
+		if sub.initialized {
+			continue
+		}
+		if _, err := runGit(dir, "submodule", "update", "--init", "--depth", "1", "--", sub.path); err != nil {
+			warn(warnSubmodule, filepath.Join(dir, filepath.FromSlash(sub.path)), "failed to initialize: %v", err)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// gitParts limits --include-git to these paths inside .git, e.g. refs, HEAD
+// and config, leaving out the object database
+var gitParts []string
+
+// validateGitParts checks and cleans the --git-parts values
+func validateGitParts() error {
+	if len(gitParts) == 0 {
+		return nil
+	}
+	if !includeGit {
+		return fmt.Errorf("--git-parts requires --include-git")
+	}
+	for i, part := range gitParts {
+		cleaned := path.Clean(filepath.ToSlash(part))
+		if !isLocalSlash(cleaned) {
+			return fmt.Errorf("invalid --git-parts value %q: it must be a relative path inside .git", part)
+		}
+		gitParts[i] = cleaned
+	}
+	return nil
+}
+
+// outsideGitParts reports whether path lies inside a .git directory but not
+// in one of the selected parts. The .git directory itself and the
+// directories leading to a part are kept so the walk can reach it.
+func (f *Filter) outsideGitParts(p string) bool {
+	if len(f.gitParts) == 0 {
+		return false
+	}
+	parts := strings.Split(filepath.ToSlash(p), "/")
+	inner := -1
+	for i, part := range parts {
+		if part == ".git" {
+			inner = i + 1
+		}
+	}
+	if inner < 0 || inner == len(parts) {
+		return false
+	}
+	rel := strings.Join(parts[inner:], "/")
+	for _, part := range f.gitParts {
+		if rel == part || strings.HasPrefix(rel, part+"/") || strings.HasPrefix(part, rel+"/") {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// Statuses recorded by --git-status
+const (
+	gitUnchanged = "unchanged"
+	gitModified  = "modified"
+	gitUntracked = "untracked"
+)
+
+// annotateGitStatus compares the files below root, loaded from dir, with
+// their blobs in HEAD. Hashes are computed by git itself so that clean
+// filters and line-ending conversion are accounted for. Files reached
+// through symlinks or inside submodules are left unmarked.
+func annotateGitStatus(dir string, root *FileEntry) error {
+	blobs, err := gitHeadBlobs(dir)
+	if err != nil {
+		return fmt.Errorf("--git-status requires a git repository with a commit: %w", err)
+	}
+	var submodules []string
+	for path, blob := range blobs {
+		if blob == "" {
+			submodules = append(submodules, path+"/")
+		}
+	}
+	var pending []*FileEntry
+	var paths []string
+	var walk func(entry *FileEntry)
+	walk = func(entry *FileEntry) {
+		if entry.IsDir {
+			for _, child := range entry.Children {
+				walk(child)
+			}
+			return
+		}
+		if entry.LinkTarget != "" {
+			return
+		}
+		for _, sub := range submodules {
+			if strings.HasPrefix(entry.name, sub) {
+				return
+			}
+		}
+		if _, tracked := blobs[entry.name]; !tracked {
+			entry.GitStatus = gitUntracked
+			return
+		}
+		// hash-object reads one path per line
+		abs, err := filepath.Abs(entry.Path)
+		if err != nil || strings.Contains(abs, "\n") {
+			return
+		}
+		pending = append(pending, entry)
+		paths = append(paths, abs)
+	}
+	walk(root)
+	hashes, err := gitHashObjects(dir, paths)
+	if err != nil {
+		return fmt.Errorf("failed to hash files for --git-status: %w", err)
+	}
+	for i, entry := range pending {
+		if hashes[i] == blobs[entry.name] {
+			entry.GitStatus = gitUnchanged
+		} else {
+			entry.GitStatus = gitModified
+		}
+	}
+	return nil
+}
+
+// countGitStatus counts the modified and untracked files below entry
+func countGitStatus(entry *FileEntry) (modified int, untracked int) {
+	if !entry.IsDir {
+		switch entry.GitStatus {
+		case gitModified:
+			return 1, 0
+		case gitUntracked:
+			return 0, 1
+		}
+		return 0, 0
+	}
+	for _, child := range entry.Children {
+		m, u := countGitStatus(child)
+		modified += m
+		untracked += u
+	}
+	return modified, untracked
+}
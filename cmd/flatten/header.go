@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// fileHeaderStyles lists the values of --file-header
+var fileHeaderStyles = []string{"bullets", "yaml"}
+
+// validateFileHeader checks the --file-header value
+func validateFileHeader() error {
+	for _, style := range fileHeaderStyles {
+		if fileHeaderStyle == style {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid --file-header value %q (valid: %s)", fileHeaderStyle, strings.Join(fileHeaderStyles, ", "))
+}
+
+// fileHeader collects the metadata of a file, keyed by label, to be written
+// as bullet lines or, with --file-header yaml, as a front-matter block
+type fileHeader struct {
+	keys   []string
+	values []string
+}
+
+func (h *fileHeader) add(key string, value string) {
+	h.keys = append(h.keys, key)
+	h.values = append(h.values, value)
+}
+
+// write writes the header followed by body in a fence. bodyKey labels the
+// body, e.g. "content" or "summary"; when it is empty there is no body.
+func (h *fileHeader) write(w *strings.Builder, bodyKey string, body string) {
+	if fileHeaderStyle == "yaml" {
+		if bodyKey != "" && bodyKey != "content" {
+			h.add(bodyKey, "true")
+		}
+		w.WriteString("\n---\n")
+		for i, key := range h.keys {
+			w.WriteString(fmt.Sprintf("%s: %s\n", label(key), yamlScalar(h.values[i])))
+		}
+		w.WriteString("---\n")
+		if bodyKey != "" {
+			w.WriteString(fmt.Sprintf("```\n%s\n```\n", body))
+		}
+		return
+	}
+	for i, key := range h.keys {
+		prefix := "- "
+		if i == 0 {
+			prefix = "\n- "
+		}
+		w.WriteString(fmt.Sprintf("%s%s: %s\n", prefix, label(key), h.values[i]))
+	}
+	if bodyKey != "" {
+		w.WriteString(fmt.Sprintf("- %s:\n```\n%s\n```\n", label(bodyKey), body))
+	}
+}
+
+// yamlScalar quotes value when it would not read back as the same plain
+// YAML string
+func yamlScalar(value string) string {
+	if value == "" || strings.TrimSpace(value) != value ||
+		strings.ContainsAny(value, ":#{}[],&*?|<>=!%@`\"'\\\n") ||
+		strings.HasPrefix(value, "-") {
+		return strconv.Quote(value)
+	}
+	switch strings.ToLower(value) {
+	case "true", "false", "yes", "no", "on", "off", "null", "~":
+		return strconv.Quote(value)
+	}
+	return value
+}
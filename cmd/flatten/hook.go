@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// hookMarker identifies pre-commit hooks written by flatten, which are the
+// only ones install overwrites and uninstall removes
+const hookMarker = "# flatten-hook"
+
+// shellQuote quotes s for a POSIX shell
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// globEscape quotes the pattern metacharacters of name
+func globEscape(name string) string {
+	var sb strings.Builder
+	for _, c := range name {
+		if strings.ContainsRune(`*?[\`, c) {
+			sb.WriteRune('\\')
+		}
+		sb.WriteRune(c)
+	}
+	return sb.String()
+}
+
+// preCommitHook returns a hook that regenerates output by running flatten
+// with args, and stages it when it changed. The output itself is excluded,
+// or each run would include the previous one, and the new output is written
+// to a temporary file in the git directory, outside the flattened tree. The
+// run is reproducible, or the generation time would change it on every
+// commit.
+func preCommitHook(output string, args []string) string {
+	quoted := []string{"flatten", "--reproducible", "--exclude", shellQuote("/" + globEscape(output))}
+	for _, arg := range args {
+		quoted = append(quoted, shellQuote(arg))
+	}
+	out := shellQuote(output)
+	return fmt.Sprintf(`#!/bin/sh
+%s
+# Regenerates %s before each commit; remove with "flatten hook uninstall".
+set -e
+cd "$(git rev-parse --show-toplevel)"
+tmp="$(git rev-parse --git-dir)/flatten-output.tmp"
+mkdir -p "$(dirname %s)"
+%s > "$tmp" || { rm -f "$tmp"; exit 1; }
+if cmp -s "$tmp" %s; then
+	rm -f "$tmp"
+else
+	mv "$tmp" %s
+	git add -- %s
+fi
+`, hookMarker, output, out, strings.Join(quoted, " "), out, out, out)
+}
+
+// hookPath returns where git looks for the pre-commit hook of repo,
+// honoring core.hooksPath
+func hookPath(repo string) (string, error) {
+	out, err := runGit(repo, "rev-parse", "--git-path", "hooks/pre-commit")
+	if err != nil {
+		return "", err
+	}
+	file := strings.TrimSpace(string(out))
+	if !filepath.IsAbs(file) {
+		file = filepath.Join(repo, file)
+	}
+	return file, nil
+}
+
+// isFlattenHook reports whether file is a hook written by flatten; a missing
+// file counts as one
+func isFlattenHook(file string) (bool, error) {
+	data, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return strings.Contains(string(data), "\n"+hookMarker+"\n"), nil
+}
+
+var (
+	hookOutput string
+	hookForce  bool
+)
+
+var hookCmd = &cobra.Command{
+	Use:   "hook",
+	Short: "Manage a pre-commit hook that keeps a committed flatten output up to date",
+}
+
+var hookInstallCmd = &cobra.Command{
+	Use:   "install --output <file> [-- flatten arguments...]",
+	Short: "Install a pre-commit hook that regenerates and stages a flatten output",
+	Long: `Install writes a pre-commit hook that runs flatten with the arguments
+given after "--" from the repository root, writes the result to --output and
+stages it whenever it changed, so a shared context file stays up to date:
+
+  flatten hook install --output docs/context.txt -- --ext go,md .
+
+flatten must be on the PATH of whoever commits. The output reflects the
+working tree, including changes that aren't staged. An existing pre-commit
+hook that flatten didn't write is only replaced with --force.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if hookOutput == "" {
+			return fmt.Errorf("--output is required")
+		}
+		output := filepath.ToSlash(hookOutput)
+		if output != path.Clean(output) || !isLocalSlash(output) {
+			return fmt.Errorf("invalid --output %q: it must be a relative path inside the repository", hookOutput)
+		}
+		file, err := hookPath(".")
+		if err != nil {
+			return fmt.Errorf("failed to locate the hooks directory: %w", err)
+		}
+		ours, err := isFlattenHook(file)
+		if err != nil {
+			return err
+		}
+		if !ours && !hookForce {
+			return fmt.Errorf("%s already exists and wasn't installed by flatten; use --force to replace it", file)
+		}
+		if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(file, []byte(preCommitHook(output, args)), 0o755); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "installed %s\n", file)
+		return nil
+	},
+}
+
+var hookUninstallCmd = &cobra.Command{
+	Use:   "uninstall",
+	Short: "Remove the pre-commit hook installed by flatten",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		file, err := hookPath(".")
+		if err != nil {
+			return fmt.Errorf("failed to locate the hooks directory: %w", err)
+		}
+		if _, err := os.Stat(file); os.IsNotExist(err) {
+			return fmt.Errorf("no pre-commit hook is installed")
+		}
+		ours, err := isFlattenHook(file)
+		if err != nil {
+			return err
+		}
+		if !ours {
+			return fmt.Errorf("%s wasn't installed by flatten; leaving it alone", file)
+		}
+		if err := os.Remove(file); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "removed %s\n", file)
+		return nil
+	},
+}
+
+func init() {
+	hookInstallCmd.Flags().StringVar(&hookOutput, "output", "", "File to regenerate, relative to the repository root (e.g. docs/context.txt)")
+	hookInstallCmd.Flags().BoolVar(&hookForce, "force", false, "Replace an existing pre-commit hook")
+	hookCmd.AddCommand(hookInstallCmd, hookUninstallCmd)
+	rootCmd.AddCommand(hookCmd)
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// ignorePattern is a single compiled line of an ignore file
+type ignorePattern struct {
+	re      *regexp.Regexp
+	negate  bool
+	dirOnly bool
+	// anchored patterns match the path relative to the ignore file's
+	// directory; the others match the base name at any depth
+	anchored bool
+}
+
+// ignoreRules holds the patterns of one ignore source, in file order
+type ignoreRules struct {
+	// base is the directory, relative to the repository root, that the
+	// patterns are relative to ("" for the root)
+	base     string
+	patterns []*ignorePattern
+}
+
+// parseIgnoreLines compiles gitignore-style lines relative to base
+func parseIgnoreLines(base string, lines []string) *ignoreRules {
+	rules := &ignoreRules{base: base}
+	for _, line := range lines {
+		if p := parseIgnoreLine(line); p != nil {
+			rules.patterns = append(rules.patterns, p)
+		}
+	}
+	return rules
+}
+
+// readIgnoreFile loads an ignore file, returning nil when it doesn't exist.
+// A symlinked file is read through its link; a dangling link or an
+// unreadable file is reported as a warning and otherwise skipped, as git does.
+func readIgnoreFile(file string, base string) *ignoreRules {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		warnIgnoreFile(file, err, os.Lstat)
+		return nil
+	}
+	return parseIgnoreLines(base, strings.Split(string(data), "\n"))
+}
+
+// lstatFS is implemented by file systems that can stat a symlink itself,
+// such as os.DirFS
+type lstatFS interface {
+	Lstat(name string) (fs.FileInfo, error)
+}
+
+// warnIgnoreFile records why an ignore file couldn't be read. Files that
+// simply don't exist are the common case and pass silently; lstat tells them
+// apart from dangling symlinks.
+func warnIgnoreFile(file string, err error, lstat func(string) (fs.FileInfo, error)) {
+	if !errors.Is(err, fs.ErrNotExist) {
+		warn(warnIgnoreRead, file, "failed to read ignore file: %v", err)
+		return
+	}
+	if lstat == nil {
+		return
+	}
+	if info, lerr := lstat(file); lerr == nil && info.Mode()&fs.ModeSymlink != 0 {
+		warn(warnIgnoreRead, file, "ignore file is a dangling symlink; skipped")
+	}
+}
+
+func parseIgnoreLine(line string) *ignorePattern {
+	line = strings.TrimRight(line, "\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return nil
+	}
+	// Trailing spaces are ignored unless escaped with a backslash
+	for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, "\\ ") {
+		line = line[:len(line)-1]
+	}
+	p := &ignorePattern{}
+	if strings.HasPrefix(line, "!") {
+		p.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, "\\!") || strings.HasPrefix(line, "\\#") {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		p.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if line == "" {
+		return nil
+	}
+	if strings.Contains(line, "/") {
+		p.anchored = true
+		line = strings.TrimPrefix(line, "/")
+	}
+	re, err := regexp.Compile("^" + globToRegexp(line) + "$")
+	if err != nil {
+		return nil
+	}
+	p.re = re
+	return p
+}
+
+// globToRegexp translates a gitignore glob into a regular expression where
+// wildcards never cross a "/" except through "**"
+func globToRegexp(glob string) string {
+	var sb strings.Builder
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch c {
+		case '*':
+			if strings.HasPrefix(glob[i:], "**") {
+				atStart := i == 0 || glob[i-1] == '/'
+				rest := glob[i+2:]
+				switch {
+				case atStart && strings.HasPrefix(rest, "/"):
+					// "**/" matches zero or more leading directories
+					sb.WriteString("(?:.*/)?")
+					i += 2
+					continue
+				case atStart && rest == "":
+					// A trailing "/**" matches everything inside
+					sb.WriteString(".*")
+					i++
+					continue
+				}
+			}
+			sb.WriteString("[^/]*")
+		case '?':
+			sb.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				sb.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			sb.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		case '\\':
+			if i+1 < len(glob) {
+				i++
+				sb.WriteString(regexp.QuoteMeta(string(glob[i])))
+			} else {
+				sb.WriteString(`\\`)
+			}
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return sb.String()
+}
+
+// match reports whether rel (relative to the repository root) is matched by
+// the rules, and whether that match re-includes it. matched is false when no
+// pattern applies.
+func (r *ignoreRules) match(rel string, isDir bool) (matched bool, ignored bool) {
+	local := rel
+	if r.base != "" {
+		if !strings.HasPrefix(rel, r.base+"/") {
+			return false, false
+		}
+		local = strings.TrimPrefix(rel, r.base+"/")
+	}
+	name := path.Base(local)
+	// Later patterns take precedence over earlier ones
+	for i := len(r.patterns) - 1; i >= 0; i-- {
+		p := r.patterns[i]
+		if p.dirOnly && !isDir {
+			continue
+		}
+		subject := name
+		if p.anchored {
+			subject = local
+		}
+		if p.re.MatchString(subject) {
+			return true, !p.negate
+		}
+	}
+	return false, false
+}
+
+// gitIgnoreMatcher applies ignore sources with git's precedence: the global
+// excludes file, then info/exclude, then .gitignore files from the repository
+// root down to the file's own directory, each overriding the ones before it.
+// It also serves .flattenignore files, which follow the same rules.
+type gitIgnoreMatcher struct {
+	// fsys serves the directory the .gitignore hierarchy starts from
+	fsys fs.FS
+	// prefix is the flattened directory relative to root, with a trailing "/"
+	prefix string
+	// file is the name of the per-directory ignore files
+	file   string
+	global []*ignoreRules
+	dirs   map[string]*ignoreRules
+}
+
+func newGitIgnoreMatcher(fsys fs.FS, prefix string, file string, global []*ignoreRules) *gitIgnoreMatcher {
+	return &gitIgnoreMatcher{
+		fsys:   fsys,
+		prefix: prefix,
+		file:   file,
+		global: global,
+		dirs:   make(map[string]*ignoreRules),
+	}
+}
+
+// rulesFor loads (and caches) the ignore file of a directory relative to root
+func (m *gitIgnoreMatcher) rulesFor(dir string) *ignoreRules {
+	if rules, ok := m.dirs[dir]; ok {
+		return rules
+	}
+	var rules *ignoreRules
+	name := path.Join(dir, m.file)
+	if data, err := fs.ReadFile(m.fsys, name); err == nil {
+		rules = parseIgnoreLines(dir, strings.Split(string(data), "\n"))
+	} else {
+		var lstat func(string) (fs.FileInfo, error)
+		if l, ok := m.fsys.(lstatFS); ok {
+			lstat = l.Lstat
+		}
+		warnIgnoreFile(name, err, lstat)
+	}
+	m.dirs[dir] = rules
+	return rules
+}
+
+// Ignored reports whether rel, relative to the flattened directory, is ignored
+func (m *gitIgnoreMatcher) Ignored(rel string, isDir bool) bool {
+	full := m.prefix + rel
+	sources := append([]*ignoreRules{}, m.global...)
+	dir := ""
+	sources = append(sources, m.rulesFor(dir))
+	for _, part := range strings.Split(path.Dir(full), "/") {
+		if part == "." {
+			break
+		}
+		dir = path.Join(dir, part)
+		sources = append(sources, m.rulesFor(dir))
+	}
+	ignored := false
+	for _, rules := range sources {
+		if rules == nil {
+			continue
+		}
+		if matched, ign := rules.match(full, isDir); matched {
+			ignored = ign
+		}
+	}
+	return ignored
+}
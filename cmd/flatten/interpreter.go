@@ -0,0 +1,19 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+)
+
+var showInterpreter bool
+
+// interpreterOf returns the interpreter named by the shebang of an
+// executable file, e.g. "/usr/bin/env bash", or "" for other files. head is
+// the start of the content.
+func interpreterOf(entry *FileEntry, head []byte) string {
+	if entry.Mode.Perm()&0o111 == 0 || !bytes.HasPrefix(head, []byte("#!")) {
+		return ""
+	}
+	line, _, _ := bytes.Cut(head[2:], []byte("\n"))
+	return strings.TrimSpace(string(line))
+}
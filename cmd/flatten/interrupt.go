@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// exitInterrupted is the exit status of a run cut short by SIGINT or
+// SIGTERM, as shells report for SIGINT
+const exitInterrupted = 130
+
+var (
+	// runCtx is canceled when the run is interrupted
+	runCtx context.Context
+	// filesWritten counts the files written to the output so far
+	filesWritten int
+)
+
+// trapInterrupts returns a context that the first SIGINT or SIGTERM
+// cancels, so the run can wind down with parseable output; a second signal
+// kills the process as usual
+func trapInterrupts(parent context.Context) (context.Context, context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(parent, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+	return ctx, stop
+}
+
+// interrupted reports whether the run was interrupted, after which no more
+// files are started
+func interrupted() bool {
+	return runCtx != nil && runCtx.Err() != nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// defaultLabels holds the literal field names of the human-readable output,
+// keyed by the names accepted by --output-labels
+var defaultLabels = map[string]string{
+	"flatten":             "Flatten",
+	"generated":           "Generated",
+	"options":             "Options",
+	"checksum-algorithm":  "Checksum algorithm",
+	"directory":           "Directory",
+	"project":             "Project",
+	"filesystem":          "Filesystem",
+	"total-files":         "Total files",
+	"total-size":          "Total size",
+	"total-disk-usage":    "Total disk usage",
+	"deduplicated":        "Deduplicated",
+	"excluded":            "Excluded",
+	"sampled":             "Sampled",
+	"freshness":           "Freshness",
+	"changed":             "Changed since HEAD",
+	"permission-findings": "Permission findings",
+	"dir-tree":            "Dir tree",
+	"dir-banner":          "=== dir: {path} ===",
+	"path":                "path",
+	"id":                  "id",
+	"last-updated":        "last updated",
+	"mode":                "mode",
+	"interpreter":         "interpreter",
+	"charset":             "charset",
+	"size":                "size",
+	"disk-usage":          "disk usage",
+	"mime-type":           "mime-type",
+	"symlink-target":      "symlink-target",
+	"owner":               "owner",
+	"group":               "group",
+	"sha256":              "sha256",
+	"git":                 "git",
+	"tokens":              "tokens",
+	"dir-tokens":          "dir tokens",
+	"content":             "content",
+	"summary":             "summary",
+	"truncated":           "truncated",
+	"quick-head":          "first {shown} of {size} (--quick)",
+	"identical":           "Contents are identical to {path}",
+	"identical-dir":       "Directory identical to {path}",
+	"similarity":          "similarity",
+	"partially-identical": "{percent}% identical to {path}",
+	"footnotes":           "Footnotes",
+	"warnings":            "Warnings",
+}
+
+// outputLabels holds the overrides given with --output-labels
+var outputLabels map[string]string
+
+// label returns the output label for key, honoring overrides
+func label(key string) string {
+	if override, ok := outputLabels[key]; ok {
+		return override
+	}
+	return defaultLabels[key]
+}
+
+// identicalTo renders the deduplication notice pointing at path
+func identicalTo(path string) string {
+	return strings.ReplaceAll(label("identical"), "{path}", path)
+}
+
+// validateLabels rejects overrides for labels that don't exist
+func validateLabels() error {
+	for key := range outputLabels {
+		if _, ok := defaultLabels[key]; !ok {
+			keys := make([]string, 0, len(defaultLabels))
+			for k := range defaultLabels {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			return fmt.Errorf("unknown output label %q (valid: %s)", key, strings.Join(keys, ", "))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// lsRow is a line of the ls table
+type lsRow struct {
+	path    string
+	size    int64
+	modTime int64
+	mime    string
+	hash    string
+}
+
+// lsHashLen is how much of the SHA-256 the table shows
+const lsHashLen = 12
+
+// lsSortKeys orders rows by each --sort column, falling back to the path
+var lsSortKeys = map[string]func(a, b lsRow) bool{
+	"path":  func(a, b lsRow) bool { return a.path < b.path },
+	"size":  func(a, b lsRow) bool { return a.size < b.size },
+	"mtime": func(a, b lsRow) bool { return a.modTime < b.modTime },
+	"mime":  func(a, b lsRow) bool { return a.mime < b.mime },
+	"hash":  func(a, b lsRow) bool { return a.hash < b.hash },
+}
+
+var (
+	lsSort    string
+	lsReverse bool
+)
+
+// collectRows lists the files below entry, reading only as much of each as
+// the MIME type and hash need
+func collectRows(entry *FileEntry, rows []lsRow) []lsRow {
+	if entry.IsDir {
+		for _, child := range entry.Children {
+			rows = collectRows(child, rows)
+		}
+		return rows
+	}
+	hash, err := entry.Hash()
+	if err != nil {
+		warnReadError(entry.Path, err)
+		return rows
+	}
+	mimeType, err := mimeTypeOf(entry, func() ([]byte, error) { return entry.ReadHead(sniffLen) })
+	if err != nil {
+		warnReadError(entry.Path, err)
+		return rows
+	}
+	return append(rows, lsRow{
+		path:    entry.Path,
+		size:    entry.Size,
+		modTime: entry.ModTime,
+		mime:    mimeType,
+		hash:    hash[:lsHashLen],
+	})
+}
+
+// writeTable writes the rows as aligned columns, with sizes right-aligned
+func writeTable(w *strings.Builder, rows []lsRow) {
+	sizes := make([]string, len(rows))
+	width := len("SIZE")
+	for i, row := range rows {
+		sizes[i] = formatBytes(row.size)
+		width = max(width, len(sizes[i]))
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "%*s\tMODIFIED\tMIME\tSHA256\tPATH\n", width, "SIZE")
+	for i, row := range rows {
+		modified := time.Unix(row.modTime, 0).Format("2006-01-02 15:04")
+		fmt.Fprintf(tw, "%*s\t%s\t%s\t%s\t%s\n", width, sizes[i], modified, row.mime, row.hash, row.path)
+	}
+	tw.Flush()
+}
+
+var lsCmd = &cobra.Command{
+	Use:   "ls [directory...]",
+	Short: "List the files flatten would include, without their contents",
+	Long: `Ls prints an aligned table of the files flatten would include, with their
+size, modification time, MIME type and (abbreviated) SHA-256, as a quick
+inventory of a selection. Rows can be sorted by any column.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) == 0 {
+			args = []string{"."}
+		}
+		less, ok := lsSortKeys[lsSort]
+		if !ok {
+			return fmt.Errorf("invalid --sort value %q (valid: path, size, mtime, mime, hash)", lsSort)
+		}
+		var rows []lsRow
+		for _, dir := range args {
+			filter, err := NewFilter(dir, filterOptions())
+			if err != nil {
+				return fmt.Errorf("failed to create filter for %s: %w", dir, err)
+			}
+			realRoot, err := realPath(dir)
+			if err != nil {
+				return fmt.Errorf("failed to resolve %s: %w", dir, err)
+			}
+			root, err := loadDirectory(&walkState{
+				fsys:     os.DirFS(dir),
+				root:     dir,
+				realRoot: realRoot,
+				filter:   filter,
+				limits:   &walkLimits{},
+			}, ".")
+			if err != nil {
+				return fmt.Errorf("failed to load directory structure for %s: %w", dir, err)
+			}
+			if root != nil {
+				rows = collectRows(root, rows)
+			}
+		}
+		sort.SliceStable(rows, func(i, j int) bool {
+			a, b := rows[i], rows[j]
+			if lsReverse {
+				a, b = b, a
+			}
+			if less(a, b) != less(b, a) {
+				return less(a, b)
+			}
+			return a.path < b.path
+		})
+		var output strings.Builder
+		writeTable(&output, rows)
+		fmt.Print(output.String())
+		return nil
+	},
+}
+
+func init() {
+	lsCmd.Flags().StringVar(&lsSort, "sort", "path", "Column to sort by: path, size, mtime, mime or hash")
+	lsCmd.Flags().BoolVarP(&lsReverse, "reverse", "r", false, "Reverse the sort order")
+	lsCmd.Flags().StringSliceVarP(&includePatterns, "include", "I", []string{}, "Include only files matching these patterns (e.g. '*.go,*.js')")
+	lsCmd.Flags().StringSliceVarP(&excludePatterns, "exclude", "E", []string{}, "Exclude files matching these patterns (e.g. '*.test.js')")
+	rootCmd.AddCommand(lsCmd)
+}
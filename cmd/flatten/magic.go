@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// magicSignature identifies a file type by the bytes found at an offset
+type magicSignature struct {
+	offset   int
+	magic    []byte
+	mimeType string
+	// also, when set, must match as well (e.g. the "WEBP" inside a RIFF header)
+	also *magicSignature
+}
+
+func (s magicSignature) matches(content []byte) bool {
+	end := s.offset + len(s.magic)
+	if end > len(content) || !bytes.Equal(content[s.offset:end], s.magic) {
+		return false
+	}
+	return s.also == nil || s.also.matches(content)
+}
+
+// magicSignatures is consulted in order, so more specific signatures must
+// come before ones sharing their prefix. Entries loaded with --magic-file
+// are prepended and therefore take precedence.
+var magicSignatures = []magicSignature{
+	{magic: []byte("SQLite format 3\x00"), mimeType: "application/vnd.sqlite3"},
+	{magic: []byte("\x7fELF"), mimeType: "application/x-elf"},
+	{magic: []byte("\xcf\xfa\xed\xfe"), mimeType: "application/x-mach-binary"},
+	{magic: []byte("\xce\xfa\xed\xfe"), mimeType: "application/x-mach-binary"},
+	{magic: []byte("\xca\xfe\xba\xbe"), mimeType: "application/java-vm"},
+	{magic: []byte("MZ"), mimeType: "application/vnd.microsoft.portable-executable"},
+	{magic: []byte("\x00asm"), mimeType: "application/wasm"},
+	{magic: []byte("wOFF"), mimeType: "font/woff"},
+	{magic: []byte("wOF2"), mimeType: "font/woff2"},
+	{magic: []byte("OTTO"), mimeType: "font/otf"},
+	{magic: []byte("\x00\x01\x00\x00\x00"), mimeType: "font/ttf"},
+	{magic: []byte("ttcf"), mimeType: "font/collection"},
+	{magic: []byte("\x89PNG\r\n\x1a\n"), mimeType: "image/png"},
+	{magic: []byte("\xff\xd8\xff"), mimeType: "image/jpeg"},
+	{magic: []byte("GIF87a"), mimeType: "image/gif"},
+	{magic: []byte("GIF89a"), mimeType: "image/gif"},
+	{magic: []byte("RIFF"), mimeType: "image/webp", also: &magicSignature{offset: 8, magic: []byte("WEBP")}},
+	{magic: []byte("RIFF"), mimeType: "audio/wav", also: &magicSignature{offset: 8, magic: []byte("WAVE")}},
+	{magic: []byte("%PDF-"), mimeType: "application/pdf"},
+	{magic: []byte("PK\x03\x04"), mimeType: "application/zip"},
+	{magic: []byte("\x1f\x8b"), mimeType: "application/gzip"},
+	{magic: []byte("BZh"), mimeType: "application/x-bzip2"},
+	{magic: []byte("\xfd7zXZ\x00"), mimeType: "application/x-xz"},
+	{magic: []byte("\x28\xb5\x2f\xfd"), mimeType: "application/zstd"},
+	{magic: []byte("7z\xbc\xaf\x27\x1c"), mimeType: "application/x-7z-compressed"},
+	{offset: 257, magic: []byte("ustar"), mimeType: "application/x-tar"},
+}
+
+// sniffLen is how much of a file is read to detect its type
+const sniffLen = 2048
+
+// detectMagic returns the MIME type whose signature matches content, or ""
+func detectMagic(content []byte) string {
+	for _, sig := range magicSignatures {
+		if sig.matches(content) {
+			return sig.mimeType
+		}
+	}
+	return ""
+}
+
+// loadMagicFile reads extra signatures, one per line in the form
+//
+//	<offset> <hex bytes> <mime type>
+//
+// Blank lines and lines starting with "#" are ignored.
+func loadMagicFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open magic file: %w", err)
+	}
+	defer file.Close()
+
+	var loaded []magicSignature
+	scanner := bufio.NewScanner(file)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			return fmt.Errorf("%s:%d: expected \"<offset> <hex bytes> <mime type>\"", path, lineNo)
+		}
+		offset, err := strconv.Atoi(fields[0])
+		if err != nil || offset < 0 {
+			return fmt.Errorf("%s:%d: invalid offset %q", path, lineNo, fields[0])
+		}
+		magic, err := hex.DecodeString(fields[1])
+		if err != nil || len(magic) == 0 {
+			return fmt.Errorf("%s:%d: invalid hex bytes %q", path, lineNo, fields[1])
+		}
+		loaded = append(loaded, magicSignature{offset: offset, magic: magic, mimeType: fields[2]})
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read magic file: %w", err)
+	}
+	magicSignatures = append(loaded, magicSignatures...)
+	return nil
+}
+
+// isTextMime reports whether a MIME type describes human-readable text
+func isTextMime(mimeType string) bool {
+	return strings.HasPrefix(mimeType, "text/") ||
+		strings.Contains(mimeType, "json") ||
+		strings.Contains(mimeType, "xml") ||
+		strings.Contains(mimeType, "javascript")
+}
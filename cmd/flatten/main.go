@@ -1,18 +1,24 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"hash/fnv"
+	"io"
 	"io/fs"
 	"mime"
 	"net/http"
 	"os"
-	"os/user"
+	"path"
 	"path/filepath"
 	"strings"
 	"syscall"
 	"time"
+	"unicode/utf8"
 
 	"github.com/pkoukk/tiktoken-go"
 	"github.com/spf13/cobra"
@@ -20,21 +26,164 @@ import (
 
 // FileEntry represents a file in the flattened structure
 type FileEntry struct {
-	Path     string
-	IsDir    bool
-	Size     int64
-	Mode     fs.FileMode
-	ModTime  int64
-	Content  []byte
-	Tokens   int
-	Children []*FileEntry
+	Path  string
+	IsDir bool
+	Size  int64
+	// DiskUsage is the space allocated to the file, which differs from Size
+	// for sparse and compressed files and is 0 where it isn't known
+	DiskUsage int64
+	Mode      fs.FileMode
+	ModTime   int64
+	Tokens    int
+	Children  []*FileEntry
+	// LinkTarget is the symlink target when the entry was reached through one
+	LinkTarget string
+	// GitStatus is set by --git-status: unchanged, modified or untracked
+	GitStatus string
+	// Submodule is the commit checked out in a submodule directory
+	Submodule string
+
+	// fsys and name locate the entry in the file system it was loaded from
+	fsys fs.FS
+	name string
+	// project is the project declared by a manifest in a directory
+	project *projectInfo
+	// filesystem describes the file system of a root under --fs-info
+	filesystem *fsInfo
+	// changed is set when the file changed after it was stat'ed, so hashes
+	// taken before its content was read may be stale
+	changed bool
+	// cacheKey locates the file's derived fields in --metadata-cache
+	cacheKey string
+	// findings lists the --audit-perms findings below a root, including
+	// those of excluded files
+	findings []auditFinding
+}
+
+// readAttempts is how often a file that changes while it is read is read
+// again before flatten settles for the last read
+const readAttempts = 3
+
+// settled stats the file after n bytes of it were read in full and reports
+// whether it still matches the entry. When the size or mtime moved, the
+// entry takes the new ones and is marked as changed. On the last attempt
+// the file is reported with a warning and its size set to what was read.
+func (e *FileEntry) settled(n int64, attempt int) bool {
+	info, err := fs.Stat(e.fsys, e.name)
+	if err != nil {
+		return true
+	}
+	size, modTime := info.Size(), info.ModTime().Unix()
+	if size == e.Size && modTime == e.ModTime && size == n {
+		return true
+	}
+	e.changed = true
+	e.Size, e.ModTime, e.DiskUsage = size, modTime, diskUsage(info)
+	if attempt < readAttempts {
+		return false
+	}
+	warn(warnChanged, e.Path, "kept changing while being read; using the last of %d reads", readAttempts)
+	e.Size = n
+	return true
+}
+
+// ReadContent reads the file's content from disk. Content is loaded only when
+// a renderer asks for it and isn't retained afterwards, so runs that never
+// need file bodies never read them.
+//
+// Files can change between the walk and the read, e.g. logs on a busy
+// server, so the file is read again until its size and mtime hold still
+// across a read, and the entry is updated to match the content.
+func (e *FileEntry) ReadContent() ([]byte, error) {
+	for attempt := 1; ; attempt++ {
+		content, err := fs.ReadFile(e.fsys, e.name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read file %s: %w", e.Path, err)
+		}
+		if e.settled(int64(len(content)), attempt) {
+			return content, nil
+		}
+	}
+}
+
+// Hash returns the SHA-256 of the file's content. The content is streamed
+// through the hasher, so large files are never held in memory. Like
+// ReadContent, it hashes the file again when it changed during the read.
+func (e *FileEntry) Hash() (string, error) {
+	for attempt := 1; ; attempt++ {
+		hash, n, err := e.hashOnce()
+		if err != nil {
+			return "", err
+		}
+		if e.settled(n, attempt) {
+			return hash, nil
+		}
+	}
+}
+
+func (e *FileEntry) hashOnce() (string, int64, error) {
+	file, err := e.fsys.Open(e.name)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to read file %s: %w", e.Path, err)
+	}
+	defer file.Close()
+	hasher := sha256.New()
+	n, err := io.Copy(hasher, file)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to read file %s: %w", e.Path, err)
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), n, nil
+}
+
+// ReadHead reads up to n bytes from the start of the file
+func (e *FileEntry) ReadHead(n int) ([]byte, error) {
+	file, err := e.fsys.Open(e.name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file %s: %w", e.Path, err)
+	}
+	defer file.Close()
+	head, err := io.ReadAll(io.LimitReader(file, int64(n)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file %s: %w", e.Path, err)
+	}
+	return head, nil
 }
 
 // FileHash is used for deduplication
 type FileHash struct {
-	Path    string
-	Hash    string
-	Content []byte
+	Path string
+	Hash string
+	// entry is re-read to confirm matches on the fast, non-cryptographic key
+	entry *FileEntry
+}
+
+// renderState carries what the rendering of one root accumulates: counts of
+// the files whose content was elided by deduplication, and the directory of
+// the last file written, for directory banners
+type renderState struct {
+	Files   int
+	Partial int
+	Bytes   int64
+	// chunks indexes chunk contents for --chunk-dedup
+	chunks chunkIndex
+	// banners is set when directory banners are written
+	banners bool
+	lastDir string
+	// footnotes numbers the files duplicates refer to under
+	// --dedup-style footnote, in the order of footnoteOrder
+	footnotes     map[string]int
+	footnoteOrder []string
+	// Dirs counts directories written as identical to an earlier one.
+	// dirShapes holds the shapes of the directories that may repeat another
+	// one and seenDirs the directories written so far with each shape;
+	// dirKeys and fileKeys cache the keys they are compared by.
+	Dirs      int
+	dirShapes map[*FileEntry]string
+	seenDirs  map[string][]*FileEntry
+	dirKeys   map[*FileEntry]string
+	fileKeys  map[*FileEntry]string
+	// editorConfig resolves .editorconfig properties with --editorconfig
+	editorConfig *editorConfigMatcher
 }
 
 // Flags
@@ -43,19 +192,64 @@ var (
 	includeGit          bool
 	includeBin          bool
 	noFileDeduplication bool
+	untrackedOnly       bool
+	sparseOnly          bool
+	submodules          string
 
 	showLastUpdated bool
 	showFileMode    bool
 	showFileSize    bool
+	showDiskUsage   bool
 	showMimeType    bool
 	showSymlinks    bool
 	showOwnership   bool
+	numericOwner    bool
 	showChecksum    bool
 	showAllMetadata bool
+	metadataSpec    []string
 
 	showTokens  bool
 	tokensModel string
 
+	treeOnly bool
+	noHeader bool
+	noTree   bool
+
+	sidecarPath string
+
+	porcelain     bool
+	outputFormat  string
+	listOnly      bool
+	nullSeparated bool
+
+	warningsPath string
+
+	magicFile string
+
+	maxFiles   int
+	onMaxFiles string
+	timeout    time.Duration
+
+	followSymlinks bool
+	confineToRoot  bool
+
+	chunkDedup bool
+	gitStatus  bool
+	auditPerms bool
+
+	extensions   []string
+	skipEmpty    bool
+	sampleCount  int
+	contentOrder string
+	dirBanners   bool
+
+	fileHeaderStyle string
+	fileIDs         bool
+
+	summarizeOver      string
+	summarizeOverBytes int64
+	summarizer         string
+
 	includePatterns []string
 	excludePatterns []string
 )
@@ -73,47 +267,237 @@ func sumTokens(entry *FileEntry) int {
 	return total
 }
 
-func loadDirectory(path string, filter *Filter, tokenizer *tiktoken.Tiktoken) (*FileEntry, error) {
-	info, err := os.Stat(path)
-	if err != nil {
-		return nil, fmt.Errorf("failed to stat path %s: %w", path, err)
+// walkState carries the settings and shared counters of a walk
+type walkState struct {
+	fsys fs.FS
+	root string
+	// realRoot is the resolved root on the OS file system, used to confine
+	// symlinks; it is empty for walks of other file systems
+	realRoot  string
+	filter    *Filter
+	tokenizer *tiktoken.Tiktoken
+	limits    *walkLimits
+	// findings collects the --audit-perms findings of the walk, which
+	// compares owners with the owner of the root
+	findings       []auditFinding
+	rootOwner      uint32
+	rootOwnerKnown bool
+}
+
+// walkLimits caps a run; it is shared by all the roots of the run
+type walkLimits struct {
+	// ctx ends the walk early when it is done, e.g. on --timeout
+	ctx       context.Context
+	maxFiles  int
+	failOnMax bool
+	files     int
+	truncated bool
+	// maxDepth is how many levels of directories are walked below the root,
+	// or 0 for all; shallow records that deeper ones were left unwalked
+	maxDepth int
+	shallow  bool
+}
+
+// stopped reports whether the walk should take no more entries, recording a
+// truncation warning the first time the context ends it
+func (l *walkLimits) stopped() bool {
+	if l.truncated {
+		return true
 	}
-	if !filter.ShouldInclude(info, path) {
-		return nil, nil
+	if l.ctx == nil || l.ctx.Err() == nil {
+		return false
 	}
-	entry := &FileEntry{
-		Path:     path,
-		IsDir:    info.IsDir(),
-		Size:     info.Size(),
-		Mode:     info.Mode(),
-		ModTime:  info.ModTime().Unix(),
-		Children: make([]*FileEntry, 0),
+	l.truncated = true
+	// An interrupted run reports itself once it has written what it has
+	if errors.Is(l.ctx.Err(), context.DeadlineExceeded) {
+		warn(warnTruncated, "", "walk timed out after %s (--timeout); output is incomplete", timeout)
+	} else if !interrupted() {
+		warn(warnTruncated, "", "walk canceled; output is incomplete")
 	}
-	if !info.IsDir() {
-		content, err := os.ReadFile(path)
+	return true
+}
+
+// errMaxFiles aborts a walk that selected more than --max-files files
+var errMaxFiles = errors.New("too many files selected")
+
+// admitFile counts a selected file against the limits. It returns false once
+// the walk should stop taking files, and errMaxFiles if it should fail.
+func (l *walkLimits) admitFile() (bool, error) {
+	if l.stopped() {
+		return false, nil
+	}
+	l.files++
+	if l.maxFiles <= 0 || l.files <= l.maxFiles {
+		return true, nil
+	}
+	if l.failOnMax {
+		return false, fmt.Errorf("%w: more than %d (raise --max-files or narrow the selection)", errMaxFiles, l.maxFiles)
+	}
+	l.truncated = true
+	warn(warnTruncated, "", "stopped after %d files (--max-files)", l.maxFiles)
+	return false, nil
+}
+
+// walkFrame is a directory on the walk stack with the children it has yet to
+// visit
+type walkFrame struct {
+	entry    *FileEntry
+	children []fs.DirEntry
+}
+
+// loadDirectory loads the entry called name in the walked file system, and
+// everything below it when it is a directory. The walk keeps its own stack
+// rather than recursing, so arbitrarily deep trees can't exhaust the
+// goroutine stack.
+func loadDirectory(w *walkState, name string) (*FileEntry, error) {
+	root, children, err := loadEntry(w, name, nil)
+	if err != nil || root == nil || !root.IsDir {
+		if root != nil {
+			root.findings = w.findings
+		}
+		return root, err
+	}
+	stack := []*walkFrame{{entry: root, children: children}}
+	for len(stack) > 0 {
+		top := stack[len(stack)-1]
+		if len(top.children) == 0 {
+			stack = stack[:len(stack)-1]
+			// A directory is always the last child of its parent while it
+			// is on the stack, so an empty one is simply dropped
+			if skipEmpty && len(top.entry.Children) == 0 && len(stack) > 0 {
+				parent := stack[len(stack)-1].entry
+				parent.Children = parent.Children[:len(parent.Children)-1]
+				w.filter.CountExclusion(ReasonEmpty)
+			}
+			continue
+		}
+		d := top.children[0]
+		top.children = top.children[1:]
+		childName := path.Join(top.entry.name, d.Name())
+		child, children, err := loadEntry(w, childName, d)
+		if errors.Is(err, errMaxFiles) {
+			return nil, err
+		}
 		if err != nil {
-			return nil, fmt.Errorf("failed to read file %s: %w", path, err)
+			// A single unreadable entry shouldn't sink the whole run
+			warnReadError(filepath.Join(w.root, filepath.FromSlash(childName)), err)
+			continue
 		}
-		entry.Content = content
-		if tokenizer != nil {
-			toks := tokenizer.Encode(string(content), nil, nil)
-			entry.Tokens = len(toks)
+		if child == nil {
+			continue
+		}
+		top.entry.Children = append(top.entry.Children, child)
+		if child.IsDir && w.limits.maxDepth > 0 && strings.Count(childName, "/") >= w.limits.maxDepth {
+			if len(children) > 0 && !w.limits.shallow {
+				w.limits.shallow = true
+				warn(warnTruncated, "", "directories more than %d levels deep were not walked (--quick)", w.limits.maxDepth)
+			}
+			continue
+		}
+		if child.IsDir {
+			stack = append(stack, &walkFrame{entry: child, children: children})
 		}
-		return entry, nil
 	}
-	entries, err := os.ReadDir(path)
+	root.findings = w.findings
+	return root, nil
+}
+
+// loadEntry loads a single entry, returning nil when it is excluded. d is
+// the entry as listed by its parent directory, or nil for the root. For a
+// directory it also returns the listing of its children.
+func loadEntry(w *walkState, name string, d fs.DirEntry) (*FileEntry, []fs.DirEntry, error) {
+	if w.limits.stopped() {
+		return nil, nil, nil
+	}
+	fullPath := filepath.Join(w.root, filepath.FromSlash(name))
+	// Most exclusions only need the name and type from the listing, so
+	// excluded entries (and whole ignored directories) are pruned before
+	// they are stat'ed. Symlinks are stat'ed first to learn what they
+	// point to.
+	pruned := d != nil && d.Type()&fs.ModeSymlink == 0
+	if pruned {
+		if reason := w.filter.PathExclusionReason(fullPath, d.IsDir()); reason != "" {
+			w.filter.CountExclusion(reason)
+			return nil, nil, nil
+		}
+	}
+	info, err := fs.Stat(w.fsys, name)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read directory %s: %w", path, err)
+		return nil, nil, fmt.Errorf("failed to stat path %s: %w", fullPath, err)
+	}
+	if !pruned {
+		if reason := w.filter.PathExclusionReason(fullPath, info.IsDir()); reason != "" {
+			w.filter.CountExclusion(reason)
+			return nil, nil, nil
+		}
+	}
+	// Symlinks are checked before the filters that open files, so a link
+	// leading outside the root is never read
+	var linkTarget string
+	if name != "." {
+		var reason string
+		if linkTarget, reason = w.checkSymlink(fullPath); reason != "" {
+			w.filter.CountExclusion(reason)
+			return nil, nil, nil
+		}
+	}
+	if auditPerms {
+		w.auditEntry(name, fullPath, info)
 	}
-	for _, item := range entries {
-		childPath := filepath.Join(path, item.Name())
-		child, err := loadDirectory(childPath, filter, tokenizer)
+	if !info.IsDir() {
+		if reason := w.filter.FileExclusionReason(fullPath); reason != "" {
+			w.filter.CountExclusion(reason)
+			return nil, nil, nil
+		}
+	}
+	if skipEmpty && !info.IsDir() && info.Size() == 0 {
+		w.filter.CountExclusion(ReasonEmpty)
+		return nil, nil, nil
+	}
+	entry, err := newFileEntry(w, name, info, linkTarget)
+	if err != nil || entry == nil || !entry.IsDir {
+		return entry, nil, err
+	}
+	if w.filter.IsSkippedSubmodule(fullPath) {
+		w.filter.CountExclusion(ReasonExcludedDir)
+		return entry, nil, nil
+	}
+	children, err := fs.ReadDir(w.fsys, name)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read directory %s: %w", fullPath, err)
+	}
+	return entry, children, nil
+}
+
+// newFileEntry creates the entry for name once it passed the filters. Files
+// count against the walk's limits and have their tokens counted when a
+// tokenizer is set.
+func newFileEntry(w *walkState, name string, info fs.FileInfo, linkTarget string) (*FileEntry, error) {
+	entry := &FileEntry{
+		Path:       filepath.Join(w.root, filepath.FromSlash(name)),
+		IsDir:      info.IsDir(),
+		Size:       info.Size(),
+		DiskUsage:  diskUsage(info),
+		Mode:       info.Mode(),
+		ModTime:    info.ModTime().Unix(),
+		Children:   make([]*FileEntry, 0),
+		LinkTarget: linkTarget,
+		fsys:       w.fsys,
+		name:       name,
+		cacheKey:   metadataCacheKey(info),
+	}
+	if info.IsDir() {
+		return entry, nil
+	}
+	if ok, err := w.limits.admitFile(); !ok {
+		return nil, err
+	}
+	if w.tokenizer != nil {
+		tokens, err := countTokens(w, entry)
 		if err != nil {
 			return nil, err
 		}
-		if child != nil {
-			entry.Children = append(entry.Children, child)
-		}
+		entry.Tokens = tokens
 	}
 	return entry, nil
 }
@@ -140,6 +524,36 @@ func getTotalSize(entry *FileEntry) int64 {
 	return total
 }
 
+// getTotalDiskUsage sums the space allocated to the files below entry
+func getTotalDiskUsage(entry *FileEntry) int64 {
+	if !entry.IsDir {
+		return entry.DiskUsage
+	}
+	var total int64
+	for _, child := range entry.Children {
+		total += getTotalDiskUsage(child)
+	}
+	return total
+}
+
+// treeLabel annotates the name of entry in the tree with its symlink
+// target, submodule commit, tokens and project
+func treeLabel(entry *FileEntry, name string, showTokens bool) string {
+	if entry.LinkTarget != "" {
+		name = fmt.Sprintf("%s -> %s", name, entry.LinkTarget)
+	}
+	if entry.Submodule != "" {
+		name = fmt.Sprintf("%s @ %s", name, entry.Submodule[:min(len(entry.Submodule), submoduleShaLen)])
+	}
+	if showTokens {
+		name = fmt.Sprintf("%s (%d tokens)", name, entry.Tokens)
+	}
+	if entry.project != nil {
+		name = fmt.Sprintf("%s [%s]", name, entry.project)
+	}
+	return name
+}
+
 func renderDirTree(entry *FileEntry, prefix string, isLast bool, showTokens bool) string {
 	var sb strings.Builder
 	if entry.Path != "." {
@@ -147,11 +561,7 @@ func renderDirTree(entry *FileEntry, prefix string, isLast bool, showTokens bool
 		if isLast {
 			marker = "└── "
 		}
-		name := filepath.Base(entry.Path)
-		if showTokens {
-			name = fmt.Sprintf("%s (%d tokens)", name, entry.Tokens)
-		}
-		sb.WriteString(prefix + marker + name + "\n")
+		sb.WriteString(prefix + marker + treeLabel(entry, filepath.Base(entry.Path), showTokens) + "\n")
 	}
 	if entry.IsDir {
 		newPrefix := prefix
@@ -176,77 +586,426 @@ func calculateFileHash(content []byte) string {
 	return hex.EncodeToString(hasher.Sum(nil))
 }
 
-func printFlattenedOutput(entry *FileEntry, w *strings.Builder, fileHashes map[string]*FileHash, showTokens bool) {
+// fileIDLen is the number of hex digits in a file ID
+const fileIDLen = 8
+
+// fileID returns the --file-ids ID of content. It is derived from the
+// content alone, so it survives renames between snapshots, and duplicates
+// share the ID of the file they repeat.
+func fileID(content []byte) string {
+	return "#" + calculateFileHash(content)[:fileIDLen]
+}
+
+// dedupKey returns the key content is deduplicated under. Unless checksums
+// are displayed anyway, a 64-bit FNV hash replaces SHA-256; it is much
+// cheaper, and findDuplicate confirms its matches by comparing contents.
+func dedupKey(content []byte) string {
+	if showChecksum {
+		return calculateFileHash(content)
+	}
+	hasher := fnv.New64a()
+	hasher.Write(content)
+	return "fnv:" + hex.EncodeToString(hasher.Sum(nil))
+}
+
+// findDuplicate looks content, whose dedupKey is key, up in fileHashes,
+// returning the earlier file with the same content (if any) and the key
+// content belongs under
+func findDuplicate(fileHashes map[string]*FileHash, key string, content []byte) (*FileHash, string) {
+	existing, exists := fileHashes[key]
+	if !exists || existing.entry == nil || showChecksum {
+		return existing, key
+	}
+	if previous, err := existing.entry.ReadContent(); err == nil && bytes.Equal(previous, content) {
+		return existing, key
+	}
+	// An FNV collision: fall back to SHA-256 to keep the files apart
+	key = calculateFileHash(content)
+	return fileHashes[key], key
+}
+
+// printFlattenedOutput writes the files below entry in tree order
+func printFlattenedOutput(entry *FileEntry, w *strings.Builder, fileHashes map[string]*FileHash, state *renderState, showTokens bool) error {
 	if !entry.IsDir {
-		w.WriteString(fmt.Sprintf("\n- path: %s\n", entry.Path))
-		if showAllMetadata || showLastUpdated {
-			w.WriteString(fmt.Sprintf("- last updated: %s\n", time.Unix(entry.ModTime, 0).Format(time.RFC3339)))
-		}
-		if showAllMetadata || showFileMode {
-			w.WriteString(fmt.Sprintf("- mode: %s\n", entry.Mode.String()))
-		}
-		if showAllMetadata || showFileSize {
-			w.WriteString(fmt.Sprintf("- size: %d bytes\n", entry.Size))
+		return printFile(entry, w, fileHashes, state, showTokens)
+	}
+	if printIdenticalDir(entry, w, state) {
+		return nil
+	}
+	printDirTokens(entry, w, showTokens)
+	for _, child := range entry.Children {
+		if err := printFlattenedOutput(child, w, fileHashes, state, showTokens); err != nil {
+			return err
 		}
-		if showAllMetadata || showMimeType {
-			mimeType := guessMimeType(entry.Path, entry.Content)
-			w.WriteString(fmt.Sprintf("- mime-type: %s\n", mimeType))
+	}
+	return nil
+}
+
+// printDirTokens writes the token count of a directory under --tokens
+func printDirTokens(entry *FileEntry, w *strings.Builder, showTokens bool) {
+	if showTokens {
+		w.WriteString(fmt.Sprintf("\n- %s: %s\n", label("path"), entry.Path))
+		w.WriteString(fmt.Sprintf("- %s: %d\n", label("dir-tokens"), entry.Tokens))
+	}
+}
+
+// printFile writes a file's metadata and content
+func printFile(entry *FileEntry, w *strings.Builder, fileHashes map[string]*FileHash, state *renderState, showTokens bool) error {
+	if interrupted() {
+		return nil
+	}
+	content, whole, err := readShown(entry)
+	if err != nil {
+		warnReadError(entry.Path, err)
+		return nil
+	}
+	filesWritten++
+	// --editorconfig and --quick change what is displayed, while checksums,
+	// IDs and deduplication look at the file's bytes
+	rendered, charset := applyEditorConfig(state.editorConfig, entry, content)
+	body, cut := quickHead(rendered)
+	if !utf8.Valid(body) {
+		warn(warnEncoding, entry.Path, "content is not valid UTF-8")
+	}
+	size := int64(len(rendered))
+	if !whole {
+		size = entry.Size
+	}
+	// Omitted duplicates leave no trace in the contents, not even a banner
+	if dedupStyle == "omit" && !noFileDeduplication {
+		if existing, _ := findDuplicate(fileHashes, state.contentKey(entry, content), content); existing != nil {
+			state.Files++
+			state.Bytes += int64(len(content))
+			return nil
 		}
-		if showAllMetadata || (showSymlinks && entry.Mode&os.ModeSymlink != 0) {
-			target, err := os.Readlink(entry.Path)
-			if err == nil {
-				w.WriteString(fmt.Sprintf("- symlink-target: %s\n", target))
-			}
+	}
+	printBanner(entry.Path, w, state)
+	header := &fileHeader{}
+	header.add("path", entry.Path)
+	if fileIDs {
+		header.add("id", fileID(content))
+	}
+	if showLastUpdated {
+		header.add("last-updated", time.Unix(entry.ModTime, 0).Format(time.RFC3339))
+	}
+	if showFileMode {
+		header.add("mode", entry.Mode.String())
+	}
+	if charset != "" {
+		header.add("charset", charset)
+	}
+	if showInterpreter {
+		if interpreter := interpreterOf(entry, content); interpreter != "" {
+			header.add("interpreter", interpreter)
 		}
-		if showAllMetadata || showOwnership {
-			info, err := os.Stat(entry.Path)
-			if err == nil {
-				if stat, ok := info.Sys().(*syscall.Stat_t); ok {
-					if owner, err := user.LookupId(fmt.Sprint(stat.Uid)); err == nil {
-						w.WriteString(fmt.Sprintf("- owner: %s\n", owner.Username))
-					}
-					if group, err := user.LookupGroupId(fmt.Sprint(stat.Gid)); err == nil {
-						w.WriteString(fmt.Sprintf("- group: %s\n", group.Name))
-					}
-				}
+	}
+	if showFileSize {
+		header.add("size", fmt.Sprintf("%d bytes", entry.Size))
+	}
+	if showDiskUsage {
+		header.add("disk-usage", fmt.Sprintf("%d bytes", entry.DiskUsage))
+	}
+	if showMimeType {
+		mimeType, _ := mimeTypeOf(entry, func() ([]byte, error) { return content, nil })
+		header.add("mime-type", mimeType)
+	}
+	if showSymlinks && entry.LinkTarget != "" {
+		header.add("symlink-target", entry.LinkTarget)
+	}
+	if showOwnership {
+		info, err := fs.Stat(entry.fsys, entry.name)
+		if err == nil {
+			if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+				header.add("owner", ownerName(stat.Uid))
+				header.add("group", groupName(stat.Gid))
 			}
 		}
-		if showAllMetadata || showChecksum {
-			hash := calculateFileHash(entry.Content)
-			w.WriteString(fmt.Sprintf("- sha256: %s\n", hash))
-		}
-		if showTokens {
-			w.WriteString(fmt.Sprintf("- tokens: %d\n", entry.Tokens))
-		}
-		if noFileDeduplication {
-			w.WriteString(fmt.Sprintf("- content:\n```\n%s\n```\n", string(entry.Content)))
-			return
+	}
+	if showChecksum {
+		header.add("sha256", calculateFileHash(content))
+	}
+	if entry.GitStatus != "" {
+		header.add("git", entry.GitStatus)
+	}
+	if showTokens {
+		header.add("tokens", fmt.Sprint(entry.Tokens))
+	}
+	if cut {
+		header.add("truncated", strings.ReplaceAll(strings.ReplaceAll(label("quick-head"), "{shown}", formatBytes(int64(len(body)))), "{size}", formatBytes(size)))
+	}
+	if noFileDeduplication {
+		header.write(w, "content", string(body))
+		return nil
+	}
+	existing, hash := findDuplicate(fileHashes, state.contentKey(entry, content), content)
+	if existing != nil {
+		state.Files++
+		state.Bytes += int64(len(content))
+		reference := existing.Path
+		if fileIDs {
+			reference = fileID(content)
 		}
-		hash := calculateFileHash(entry.Content)
-		if existing, exists := fileHashes[hash]; exists {
-			w.WriteString(fmt.Sprintf("- content: Contents are identical to %s\n", existing.Path))
+		if dedupStyle == "footnote" {
+			header.add("content", state.footnote(reference))
 		} else {
-			fileHashes[hash] = &FileHash{Path: entry.Path, Hash: hash, Content: entry.Content}
-			w.WriteString(fmt.Sprintf("- content:\n```\n%s\n```\n", string(entry.Content)))
+			header.add("content", identicalTo(reference))
 		}
-		return
+		header.write(w, "", "")
+		return nil
 	}
-	if showTokens {
-		w.WriteString(fmt.Sprintf("\n- path: %s\n", entry.Path))
-		w.WriteString(fmt.Sprintf("- dir tokens: %d\n", entry.Tokens))
+	fileHashes[hash] = &FileHash{Path: entry.Path, Hash: hash, entry: entry}
+	if summarizeOverBytes > 0 && int64(len(content)) > summarizeOverBytes {
+		summary, err := summarize(entry.Path, rendered)
+		if err == nil {
+			header.write(w, "summary", summary)
+			return nil
+		}
+		warn(warnSummarizer, entry.Path, "summarizer failed, including the full content: %v", err)
 	}
-	for _, child := range entry.Children {
-		printFlattenedOutput(child, w, fileHashes, showTokens)
+	if chunkDedup && len(body) >= chunkDedupMinFile {
+		runs, shared, source := state.chunks.match(entry.Path, body)
+		if float64(shared) >= chunkDedupMinShare*float64(len(body)) {
+			state.Partial++
+			state.Bytes += int64(shared)
+			percent := shared * 100 / len(body)
+			header.add("similarity", strings.ReplaceAll(strings.ReplaceAll(label("partially-identical"), "{percent}", fmt.Sprint(percent)), "{path}", source))
+			header.write(w, "content", renderPartial(runs))
+			return nil
+		}
 	}
+	header.write(w, "content", string(body))
+	return nil
 }
 
+// guessMimeType identifies a file by its magic number, then by its
+// extension, and finally by sniffing its content
 func guessMimeType(path string, content []byte) string {
+	if mimeType := detectMagic(content); mimeType != "" {
+		return mimeType
+	}
 	if mimeType := mime.TypeByExtension(filepath.Ext(path)); mimeType != "" {
 		return mimeType
 	}
 	return http.DetectContentType(content)
 }
 
+// filterOptions builds the filter configuration from the command-line flags
+func filterOptions() FilterOptions {
+	return FilterOptions{
+		IncludeGitIgnore: includeGitIgnore,
+		IncludeGit:       includeGit,
+		GitParts:         gitParts,
+		IncludeBin:       includeBin,
+		UntrackedOnly:    untrackedOnly,
+		SparseOnly:       sparseOnly,
+		SkipSubmodules:   submodules == "skip",
+		IncludePatterns:  append(append([]string{}, includePatterns...), extensionPatterns(extensions)...),
+		ExcludePatterns:  excludePatterns,
+		ExcludeContent:   contentPatterns,
+		ContentHead:      contentHead,
+	}
+}
+
+// loadTokenizer returns the tokenizer for --tokens-model, or nil when token
+// counting is disabled
+func loadTokenizer() (*tiktoken.Tiktoken, error) {
+	if !showTokens {
+		return nil, nil
+	}
+	tokenizer, err := tiktoken.EncodingForModel(tokensModel)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tokenizer for model %q: %w", tokensModel, err)
+	}
+	return tokenizer, nil
+}
+
+// renderRoot writes the summary, tree and file contents of one root directory
+func renderRoot(w *strings.Builder, dir string, root *FileEntry, filter *Filter, fileHashes map[string]*FileHash) error {
+	if readmeFirst {
+		elevateReadmes(root)
+	}
+	if listOnly {
+		writePathList(w, root)
+		return nil
+	}
+	switch outputFormat {
+	case "porcelain":
+		return writePorcelain(w, dir, root, fileHashes)
+	case "fbin":
+		return writeFbin(w, dir, root, fileHashes)
+	}
+	if showTokens {
+		sumTokens(root)
+	}
+	annotateProjects(root)
+	// Contents are rendered first so the header can report what
+	// deduplication saved
+	var body strings.Builder
+	state := renderState{chunks: make(chunkIndex), banners: dirBanners}
+	if useEditorConfig {
+		state.editorConfig = newEditorConfigMatcher(root.fsys)
+	}
+	if !treeOnly {
+		if !noFileDeduplication {
+			if shapes := repeatableDirs(root); len(shapes) > 0 {
+				state.dirShapes = shapes
+				state.seenDirs = make(map[string][]*FileEntry)
+				state.dirKeys = make(map[*FileEntry]string)
+				state.fileKeys = make(map[*FileEntry]string)
+			}
+		}
+		if err := writeBody(root, &body, fileHashes, &state); err != nil {
+			return err
+		}
+		writeFootnotes(&body, &state)
+	}
+	if !noHeader {
+		w.WriteString(fmt.Sprintf("\n%s: %s\n", label("directory"), dir))
+		if root.project != nil {
+			w.WriteString(fmt.Sprintf("- %s: %s\n", label("project"), root.project))
+		}
+		if root.filesystem != nil {
+			w.WriteString(fmt.Sprintf("- %s: %s\n", label("filesystem"), root.filesystem))
+		}
+		w.WriteString(fmt.Sprintf("- %s: %d\n", label("total-files"), getTotalFiles(root)))
+		w.WriteString(fmt.Sprintf("- %s: %d bytes\n", label("total-size"), getTotalSize(root)))
+		if showDiskUsage {
+			w.WriteString(fmt.Sprintf("- %s: %d bytes\n", label("total-disk-usage"), getTotalDiskUsage(root)))
+		}
+		if state.Files > 0 || state.Partial > 0 {
+			partial := ""
+			if state.Partial > 0 {
+				partial = fmt.Sprintf(", %d partially identical", state.Partial)
+			}
+			if state.Dirs > 0 {
+				partial += fmt.Sprintf(", %d identical directories", state.Dirs)
+			}
+			w.WriteString(fmt.Sprintf("- %s: %d duplicate files%s, %s saved\n", label("deduplicated"), state.Files, partial, formatBytes(state.Bytes)))
+		}
+		if summary := exclusionSummary(filter.Exclusions()); summary != "" {
+			w.WriteString(fmt.Sprintf("- %s: %s\n", label("excluded"), summary))
+		}
+		if sampled := filter.Exclusions()[ReasonSampled]; sampled > 0 {
+			files := getTotalFiles(root)
+			w.WriteString(fmt.Sprintf("- %s: %d of %d files (%.1f%%)\n", label("sampled"), files, files+sampled, 100*float64(files)/float64(files+sampled)))
+		}
+		if showFreshness {
+			w.WriteString(fmt.Sprintf("- %s: %s\n", label("freshness"), freshnessSummary(root, time.Now())))
+		}
+		if gitStatus {
+			modified, untracked := countGitStatus(root)
+			w.WriteString(fmt.Sprintf("- %s: %d modified, %d untracked\n", label("changed"), modified, untracked))
+		}
+	}
+	if auditPerms {
+		writeAuditFindings(w, root)
+	}
+	if !noTree {
+		tree, err := renderTree(root, showTokens)
+		if err != nil {
+			return err
+		}
+		w.WriteString(fmt.Sprintf("- %s:\n%s\n", label("dir-tree"), tree))
+	}
+	w.WriteString(body.String())
+	return nil
+}
+
+// exclusionSummary renders exclusion counts as "12 (gitignore: 10, binary: 2)"
+func exclusionSummary(exclusions map[string]int) string {
+	total := 0
+	var parts []string
+	for _, reason := range exclusionReasons {
+		if n := exclusions[reason]; n > 0 {
+			total += n
+			parts = append(parts, fmt.Sprintf("%s: %d", reason, n))
+		}
+	}
+	if total == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d (%s)", total, strings.Join(parts, ", "))
+}
+
+// formatBytes renders a byte count with a human-readable unit
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// validateOptions checks the flags and derives the settings they imply. It
+// is shared by the command line and the browser build, which both call it
+// once the flags are parsed.
+func validateOptions() error {
+	if err := applyMetadataSelection(metadataSpec); err != nil {
+		return err
+	}
+	if err := validateLabels(); err != nil {
+		return err
+	}
+	switch submodules {
+	case "include", "skip", "recurse":
+	default:
+		return fmt.Errorf("invalid --submodules value %q (valid: include, skip, recurse)", submodules)
+	}
+	if onMaxFiles != "fail" && onMaxFiles != "stop" {
+		return fmt.Errorf("invalid --on-max-files value %q (valid: fail, stop)", onMaxFiles)
+	}
+	if onHashMismatch != "fail" && onHashMismatch != "warn" {
+		return fmt.Errorf("invalid --on-hash-mismatch value %q (valid: fail, warn)", onHashMismatch)
+	}
+	if err := resolveFormat(); err != nil {
+		return err
+	}
+	if listOnly && outputFormat != "markdown" {
+		return fmt.Errorf("--list-only and --format %s cannot be used together", outputFormat)
+	}
+	if nullSeparated && !porcelain && !listOnly {
+		return fmt.Errorf("--null requires --porcelain or --list-only")
+	}
+	if treeOnly && noTree {
+		return fmt.Errorf("--tree-only and --no-tree cannot be used together")
+	}
+	if err := validateOrder(); err != nil {
+		return err
+	}
+	if err := validateGitParts(); err != nil {
+		return err
+	}
+	if err := validateDedupStyle(); err != nil {
+		return err
+	}
+	if err := compileContentPatterns(); err != nil {
+		return err
+	}
+	if err := validateTreeFormat(); err != nil {
+		return err
+	}
+	if err := validateFileHeader(); err != nil {
+		return err
+	}
+	if (summarizeOver == "") != (summarizer == "") {
+		return fmt.Errorf("--summarize-over and --summarizer must be used together")
+	}
+	summarizeOverBytes = 0
+	if summarizeOver != "" {
+		size, err := parseByteSize(summarizeOver)
+		if err != nil {
+			return fmt.Errorf("invalid --summarize-over: %w", err)
+		}
+		summarizeOverBytes = size
+	}
+	return nil
+}
+
 var rootCmd = &cobra.Command{
 	Use:   "flatten [directories]...",
 	Short: "Flatten outputs one or more directories as a flat representation",
@@ -255,45 +1014,190 @@ a flat representation of all their contents to stdout. It recursively processes
 subdirectories and their contents for each provided directory.`,
 	Args: cobra.ArbitraryArgs,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		if len(args) == 0 {
+		started := time.Now()
+		configDir := "."
+		if len(args) > 0 {
+			configDir = args[0]
+		}
+		if !noConfig {
+			if err := applyConfig(cmd.Flags(), configDir); err != nil {
+				return err
+			}
+		}
+		if err := applyQuick(cmd.Flags()); err != nil {
+			return err
+		}
+		labels, err := parseRootLabels()
+		if err != nil {
+			return err
+		}
+		if args = labelledRoots(args, labels); len(args) == 0 {
 			args = []string{"."}
 		}
-
-		var tokenizer *tiktoken.Tiktoken
-		if showTokens {
-			var err error
-			tokenizer, err = tiktoken.EncodingForModel(tokensModel)
-			if err != nil {
-				return fmt.Errorf("failed to get tokenizer for model %q: %w", tokensModel, err)
+		if err := applyExcludeGroups(); err != nil {
+			return err
+		}
+		if err := validateOptions(); err != nil {
+			return err
+		}
+		if metadataCachePath != "" {
+			loadMetadataCache()
+		}
+		if err := loadIDMaps(); err != nil {
+			return err
+		}
+		if magicFile != "" {
+			if err := loadMagicFile(magicFile); err != nil {
+				return err
 			}
 		}
+		var fileList []listedFile
+		if filesFrom != "" {
+			if previewFilters {
+				return fmt.Errorf("--preview-filters and --files-from cannot be used together")
+			}
+			if len(args) > 1 {
+				return fmt.Errorf("--files-from takes at most one directory, which the listed paths are relative to")
+			}
+			if fileList, err = openFileList(); err != nil {
+				return err
+			}
+		}
+
+		tokenizer, err := loadTokenizer()
+		if err != nil {
+			return err
+		}
+		if previewFilters {
+			return previewPatterns(args, tokenizer)
+		}
 
 		fileHashes := make(map[string]*FileHash)
+		ctx, stop := trapInterrupts(cmd.Context())
+		defer stop()
+		runCtx = ctx
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		limits := &walkLimits{ctx: ctx, maxFiles: maxFiles, failOnMax: onMaxFiles == "fail"}
+		if quick {
+			limits.maxDepth = quickDepth
+		}
 		var output strings.Builder
+		runOptions = usedOptions(cmd.Flags())
+		writeFormatHeader(&output)
+		var sidecarFiles []FileMetadata
+		sidecarSeen := make(map[string]string)
+		var report runReport
 
 		for _, dir := range args {
-			filter, err := NewFilter(dir, includeGitIgnore, includeGit, includeBin, includePatterns, excludePatterns)
+			walkStart := time.Now()
+			if submodules == "recurse" {
+				initSubmodules(dir)
+			}
+			filter, err := NewFilter(dir, filterOptions())
 			if err != nil {
 				return fmt.Errorf("failed to create filter for %s: %w", dir, err)
 			}
-			root, err := loadDirectory(dir, filter, tokenizer)
+			if !sparseOnly {
+				if n := filter.MaterializedOutsideSparse(); n > 0 {
+					warn(warnSparse, dir, "%d files are outside the sparse-checkout cone; use --sparse to skip them", n)
+				}
+			}
+			realRoot, err := realPath(dir)
 			if err != nil {
+				return fmt.Errorf("failed to resolve %s: %w", dir, err)
+			}
+			ws := &walkState{
+				fsys:      os.DirFS(dir),
+				root:      dir,
+				realRoot:  realRoot,
+				filter:    filter,
+				tokenizer: tokenizer,
+				limits:    limits,
+			}
+			var root *FileEntry
+			if fileList != nil {
+				if root, err = loadFileList(ws, fileList); err != nil {
+					return err
+				}
+				if err := verifyFileList(root, fileList); err != nil {
+					return err
+				}
+			} else if root, err = loadDirectory(ws, "."); err != nil {
 				return fmt.Errorf("failed to load directory structure for %s: %w", dir, err)
 			}
 			if root == nil {
 				continue
 			}
-			if showTokens {
-				sumTokens(root)
+			if limits.truncated {
+				suggestExclusions(dir, root)
+			}
+			if showFSInfo {
+				if info, err := statFS(realRoot); err != nil {
+					warn(warnFSInfo, dir, "failed to describe the file system: %v", err)
+				} else {
+					root.filesystem = info
+				}
+			}
+			if sampleCount > 0 {
+				sampleTree(root, sampleCount, filter)
+			}
+			annotateSubmodules(dir, root)
+			if gitStatus {
+				if err := annotateGitStatus(dir, root); err != nil {
+					return err
+				}
+			}
+			if name := rootName(dir, labels); name != dir {
+				relabel(root, dir, name)
+			}
+			if sidecarPath != "" {
+				sidecarFiles, err = collectMetadata(root, sidecarFiles, sidecarSeen)
+				if err != nil {
+					return err
+				}
+			}
+			walked := time.Since(walkStart)
+			renderStart := time.Now()
+			if err := renderRoot(&output, rootName(dir, labels), root, filter, fileHashes); err != nil {
+				return err
+			}
+			if reportPath != "" {
+				report.Roots = append(report.Roots, newRootReport(rootName(dir, labels), root, filter, walked, time.Since(renderStart)))
+			}
+		}
+		report.Truncated = limits.truncated || limits.shallow
+
+		if sidecarPath != "" {
+			if err := writeSidecar(sidecarPath, sidecarFiles); err != nil {
+				return err
+			}
+		}
+		if err := saveMetadataCache(); err != nil {
+			return err
+		}
+		if interrupted() {
+			warn(warnInterrupted, "", "run interrupted after %d files; output is incomplete", filesWritten)
+		}
+		if warningsPath != "" {
+			if err := writeWarningsJSON(warningsPath); err != nil {
+				return err
+			}
+		}
+		writeFormatWarnings(&output)
+		if reportPath != "" {
+			if err := writeReport(reportPath, report, started, output.String()); err != nil {
+				return err
 			}
-			output.WriteString(fmt.Sprintf("\nDirectory: %s\n", dir))
-			output.WriteString(fmt.Sprintf("- Total files: %d\n", getTotalFiles(root)))
-			output.WriteString(fmt.Sprintf("- Total size: %d bytes\n", getTotalSize(root)))
-			output.WriteString(fmt.Sprintf("- Dir tree:\n%s\n", renderDirTree(root, "", false, showTokens)))
-			printFlattenedOutput(root, &output, fileHashes, showTokens)
 		}
 
 		fmt.Print(output.String())
+		if interrupted() {
+			os.Exit(exitInterrupted)
+		}
 		return nil
 	},
 }
@@ -301,28 +1205,83 @@ subdirectories and their contents for each provided directory.`,
 func init() {
 	rootCmd.Flags().BoolVarP(&includeGitIgnore, "include-gitignore", "i", false, "Include files normally ignored by .gitignore")
 	rootCmd.Flags().BoolVarP(&includeGit, "include-git", "g", false, "Include .git directory")
+	rootCmd.Flags().StringSliceVar(&gitParts, "git-parts", []string{}, "With --include-git, include only these parts of .git (e.g. 'refs,HEAD,config')")
 	rootCmd.Flags().BoolVar(&includeBin, "include-bin", false, "Include binary files in the output")
 	rootCmd.Flags().BoolVar(&noFileDeduplication, "no-dedup", false, "Disable file deduplication")
+	rootCmd.Flags().StringVar(&dedupStyle, "dedup-style", "reference", "How to write duplicate files: reference (an 'identical to' line), footnote (resolved after the contents), or omit")
+	rootCmd.Flags().BoolVar(&gitStatus, "git-status", false, "Compare files with HEAD and mark modified and untracked ones")
+	rootCmd.Flags().StringVar(&summarizeOver, "summarize-over", "", "Replace the content of files larger than this (e.g. 200KB) with the output of --summarizer")
+	rootCmd.Flags().StringVar(&summarizer, "summarizer", "", "Shell command that summarizes a file given on stdin (its path is in $FLATTEN_PATH)")
+	rootCmd.Flags().StringVar(&contentOrder, "order", "tree", "Order of file contents: tree, breadth, or by-dir (grouped under directory banners)")
+	rootCmd.Flags().BoolVar(&fileIDs, "file-ids", false, "Give each file a short content-derived ID and refer to duplicates by it")
+	rootCmd.Flags().StringVar(&fileHeaderStyle, "file-header", "bullets", "How to write file metadata: bullets, or yaml front matter")
+	rootCmd.Flags().BoolVar(&readmeFirst, "readme-first", false, "Put the README of each directory ahead of its other files and subdirectories")
+	rootCmd.Flags().BoolVar(&dirBanners, "dir-banners", false, "Write a banner such as '=== dir: src ===' whenever the contents move to another directory")
+	rootCmd.Flags().BoolVar(&chunkDedup, "chunk-dedup", false, "Also collapse regions of large files that repeat content seen in earlier files")
+	rootCmd.Flags().BoolVar(&untrackedOnly, "untracked-only", false, "Only include files that are not tracked by git")
+	rootCmd.Flags().BoolVar(&sparseOnly, "sparse", false, "Limit output to the git sparse-checkout cone")
+	rootCmd.Flags().StringVar(&submodules, "submodules", "include", "How to treat git submodules: include (as checked out), skip, or recurse (shallow-fetch uninitialized ones first)")
 
 	rootCmd.Flags().BoolVarP(&showLastUpdated, "last-updated", "l", false, "Show last updated time for each file")
 	rootCmd.Flags().BoolVarP(&showFileMode, "show-mode", "m", false, "Show file permissions")
+	rootCmd.Flags().BoolVar(&useEditorConfig, "editorconfig", false, "Render contents as .editorconfig describes them: decoded from its charset, with tabs expanded to its tab width")
+	rootCmd.Flags().BoolVar(&showInterpreter, "show-interpreter", false, "Show the shebang interpreter of executable scripts")
 	rootCmd.Flags().BoolVarP(&showFileSize, "show-size", "z", false, "Show individual file sizes")
+	rootCmd.Flags().BoolVar(&showDiskUsage, "disk-usage", false, "Show the space allocated on disk to each file and in total")
 	rootCmd.Flags().BoolVarP(&showMimeType, "show-mime", "M", false, "Show file MIME types")
 	rootCmd.Flags().BoolVarP(&showSymlinks, "show-symlinks", "y", false, "Show symlink targets")
+	rootCmd.Flags().BoolVar(&followSymlinks, "follow-symlinks", true, "Follow symlinks to files and directories")
+	rootCmd.Flags().BoolVar(&confineToRoot, "confine-to-root", true, "Refuse to follow symlinks that resolve outside the flattened directory")
 	rootCmd.Flags().BoolVarP(&showOwnership, "show-owner", "o", false, "Show file owner and group")
+	rootCmd.Flags().BoolVar(&numericOwner, "numeric-owner", false, "Show owner and group as numeric IDs instead of names")
+	rootCmd.Flags().StringVar(&uidMapFile, "uid-map", "", "Name owner IDs from this file of name:start[:count] ranges, such as /etc/subuid")
+	rootCmd.Flags().StringVar(&gidMapFile, "gid-map", "", "Name group IDs from this file of name:start[:count] ranges, such as /etc/subgid")
 	rootCmd.Flags().BoolVarP(&showChecksum, "show-checksum", "c", false, "Show SHA256 checksum of files")
+	rootCmd.Flags().StringVar(&magicFile, "magic-file", "", "Load extra MIME signatures from this file")
+	rootCmd.Flags().StringVar(&metadataCachePath, "metadata-cache", "", "Reuse MIME types and token counts of unchanged files across runs, cached in this file")
 	rootCmd.Flags().BoolVarP(&showAllMetadata, "all-metadata", "a", false, "Show all metadata")
+	rootCmd.Flags().StringSliceVar(&metadataSpec, "metadata", []string{}, "Metadata fields to show (e.g. 'mtime,size' or 'all,-owner')")
 
 	rootCmd.Flags().BoolVarP(&showTokens, "tokens", "t", false, "Show token usage for each file/directory")
 	rootCmd.Flags().StringVar(&tokensModel, "tokens-model", "gpt-4o-mini", "Model to use for token counting")
 
+	rootCmd.Flags().StringVar(&treeFormat, "tree-format", "unicode", "How to draw the directory tree: unicode (box drawing), indent, paths, or json")
+	rootCmd.Flags().BoolVar(&treeOnly, "tree-only", false, "Only print the summary and directory tree, without file contents")
+	rootCmd.Flags().BoolVar(&reproducible, "reproducible", false, "Leave the generation time out of the header, so identical trees give identical output")
+	rootCmd.Flags().BoolVar(&noHeader, "no-header", false, "Omit the header and directory summary")
+	rootCmd.Flags().BoolVar(&noTree, "no-tree", false, "Omit the directory tree")
+	rootCmd.Flags().IntVar(&maxFiles, "max-files", 0, "Maximum number of files to select (0 for no limit)")
+	rootCmd.Flags().StringVar(&onMaxFiles, "on-max-files", "fail", "What to do when --max-files is exceeded: fail, or stop and output what was gathered")
+	rootCmd.Flags().BoolVar(&quick, "quick", false, "Aim to answer within about 2 seconds: a shallow, time-boxed walk, no deduplication hashing, and the first 8 KB of each file")
+	rootCmd.Flags().DurationVar(&timeout, "timeout", 0, "Stop walking after this long (e.g. 2m) and output what was gathered")
+	rootCmd.Flags().StringToStringVar(&outputLabels, "output-labels", map[string]string{}, "Override output labels (e.g. 'path=file,content=body')")
+	rootCmd.Flags().BoolVar(&porcelain, "porcelain", false, "Emit stable, machine-readable records instead of the human-readable output")
+	rootCmd.Flags().StringVar(&outputFormat, "format", "markdown", "Output format: markdown, porcelain (same as --porcelain), or fbin (length-prefixed binary records)")
+	rootCmd.Flags().BoolVar(&dirRecords, "dir-records", false, "Also emit a record for each directory (path, mode, mtime, child count) in the porcelain and fbin formats")
+	rootCmd.Flags().BoolVarP(&nullSeparated, "null", "0", false, "Terminate porcelain fields and --list-only paths with NUL instead of newline")
+	rootCmd.Flags().BoolVar(&listOnly, "list-only", false, "Only print the paths of the included files, one per line")
+	rootCmd.Flags().StringVar(&sidecarPath, "sidecar", "", "Also write per-file metadata as a JSON array to this file")
+	rootCmd.Flags().StringVar(&warningsPath, "warnings-json", "", "Also write warnings as a JSON array to this file")
+	rootCmd.Flags().StringVar(&reportPath, "report", "", "Also write a JSON report of the run (options, durations, counts, warnings, output hash) to this file")
+
 	rootCmd.Flags().StringSliceVarP(&includePatterns, "include", "I", []string{}, "Include only files matching these patterns (e.g. '*.go,*.js')")
+	rootCmd.Flags().StringArrayVar(&rootLabelSpecs, "label", []string{}, "Show a directory under a friendly name, flattening it if it isn't an argument (e.g. 'api=./services/api')")
+	rootCmd.Flags().StringSliceVar(&withoutGroups, "without", []string{}, "Exclude the files of these exclude groups from .flatten.yaml (e.g. 'fixtures,media')")
+	rootCmd.Flags().BoolVar(&noConfig, "no-config", false, "Ignore .flatten.yaml files")
+	rootCmd.Flags().BoolVar(&auditPerms, "audit-perms", false, "List world-writable, setuid and setgid files and files with unexpected owners")
+	rootCmd.Flags().StringVar(&filesFrom, "files-from", "", "Flatten the files listed in this file ('-' for stdin), one path per line with an optional tab and sha256 to verify")
+	rootCmd.Flags().StringVar(&onHashMismatch, "on-hash-mismatch", "fail", "What to do when a listed sha256 doesn't match: fail, or warn")
+	rootCmd.Flags().BoolVar(&showFSInfo, "fs-info", false, "Show the file system type, mount point and free and total space of each directory")
+	rootCmd.Flags().BoolVar(&showFreshness, "freshness", false, "Show how many files were modified in the last day, week and month, and earlier")
+	rootCmd.Flags().IntVar(&sampleCount, "sample-files", 0, "Flatten a sample of this many files per directory argument, stratified by directory and extension (0 for all)")
+	rootCmd.Flags().BoolVar(&skipEmpty, "skip-empty", false, "Leave out empty files and directories without included files")
+	rootCmd.Flags().StringSliceVar(&extensions, "ext", []string{}, "Include only files with these extensions, plus Makefiles, Dockerfiles and the like (e.g. 'go,md,proto')")
+	rootCmd.Flags().StringArrayVar(&excludeContent, "exclude-content", []string{}, "Exclude files whose first --content-head bytes match this regular expression (e.g. '^// Code generated')")
+	rootCmd.Flags().IntVar(&contentHead, "content-head", 1024, "How many bytes of each file --exclude-content looks at")
+	rootCmd.Flags().BoolVar(&previewFilters, "preview-filters", false, "Instead of flattening, show how many files and bytes each include and exclude pattern matches")
 	rootCmd.Flags().StringSliceVarP(&excludePatterns, "exclude", "E", []string{}, "Exclude files matching these patterns (e.g. '*.test.js')")
 }
 
 func main() {
-	if err := rootCmd.Execute(); err != nil {
-		fmt.Fprintln(os.Stderr, err)
-		os.Exit(1)
-	}
+	execute()
 }
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+)
+
+// projectInfo describes the project declared by a package manifest
+type projectInfo struct {
+	manifest string
+	name     string
+	version  string
+}
+
+func (p *projectInfo) String() string {
+	s := p.name
+	if p.version != "" {
+		s += " " + p.version
+	}
+	return fmt.Sprintf("%s (%s)", s, p.manifest)
+}
+
+// manifestParsers read the name and version out of each kind of manifest, in
+// order of preference when a directory has several
+var manifestParsers = []struct {
+	file  string
+	parse func(data []byte) (name string, version string)
+}{
+	{"go.mod", parseGoMod},
+	{"package.json", parsePackageJSON},
+	{"pyproject.toml", parsePyproject},
+}
+
+// parseGoMod reads the module path; go.mod records no version of its own
+func parseGoMod(data []byte) (string, string) {
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[0] == "module" {
+			return strings.Trim(fields[1], `"`), ""
+		}
+	}
+	return "", ""
+}
+
+func parsePackageJSON(data []byte) (string, string) {
+	var pkg struct {
+		Name    string `json:"name"`
+		Version string `json:"version"`
+	}
+	if json.Unmarshal(data, &pkg) != nil {
+		return "", ""
+	}
+	return pkg.Name, pkg.Version
+}
+
+// parsePyproject reads name and version from the [project] table, or from
+// [tool.poetry] for Poetry projects. Only simple `key = "value"` lines are
+// understood, which is how these fields are written in practice.
+func parsePyproject(data []byte) (string, string) {
+	values := make(map[string]map[string]string)
+	table := ""
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "[") {
+			table = strings.Trim(line, "[] ")
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		if values[table] == nil {
+			values[table] = make(map[string]string)
+		}
+		values[table][strings.TrimSpace(key)] = strings.Trim(strings.TrimSpace(value), `"'`)
+	}
+	for _, table := range []string{"project", "tool.poetry"} {
+		if name := values[table]["name"]; name != "" {
+			return name, values[table]["version"]
+		}
+	}
+	return "", ""
+}
+
+// annotateProjects records the project of every directory below entry that
+// has a manifest. Manifests are read even when filters leave them out of the
+// output, since they still describe the directory.
+func annotateProjects(entry *FileEntry) {
+	if !entry.IsDir {
+		return
+	}
+	for _, child := range entry.Children {
+		annotateProjects(child)
+	}
+	for _, parser := range manifestParsers {
+		data, err := fs.ReadFile(entry.fsys, path.Join(entry.name, parser.file))
+		if err != nil {
+			continue
+		}
+		if name, version := parser.parse(data); name != "" {
+			entry.project = &projectInfo{manifest: parser.file, name: name, version: version}
+			return
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"time"
+)
+
+// memFile is a file or directory of a memFS
+type memFile struct {
+	data    []byte
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+// memFS is an in-memory file system keyed by slash-separated paths, for
+// trees that don't live on disk: rerendered snapshots and the files handed
+// over to the browser build. Parent directories that have no entry of their
+// own are synthesized.
+type memFS struct {
+	files map[string]*memFile
+	// dirs lists the children of every directory, sorted by name
+	dirs map[string][]fs.DirEntry
+}
+
+// newMemFS indexes files by directory, once, so walking the file system
+// costs no more than listing it
+func newMemFS(files map[string]*memFile) *memFS {
+	children := map[string]map[string]*memFile{".": {}}
+	for name, file := range files {
+		if name == "." {
+			continue
+		}
+		if file.mode.IsDir() && children[name] == nil {
+			children[name] = make(map[string]*memFile)
+		}
+		// Register name with its parent, and the parent with its own, until
+		// reaching a directory that is known already
+		for name != "." {
+			dir := path.Dir(name)
+			known := children[dir] != nil
+			if !known {
+				children[dir] = make(map[string]*memFile)
+			}
+			if file = files[name]; file == nil {
+				file = syntheticDir
+			}
+			children[dir][path.Base(name)] = file
+			if known {
+				break
+			}
+			name = dir
+		}
+	}
+	m := &memFS{files: files, dirs: make(map[string][]fs.DirEntry, len(children))}
+	for dir, names := range children {
+		entries := make([]fs.DirEntry, 0, len(names))
+		for child, file := range names {
+			entries = append(entries, &memInfo{name: child, file: file})
+		}
+		sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+		m.dirs[dir] = entries
+	}
+	return m
+}
+
+// memInfo describes an entry of a memFS
+type memInfo struct {
+	name string
+	file *memFile
+}
+
+func (i *memInfo) Name() string               { return i.name }
+func (i *memInfo) Size() int64                { return int64(len(i.file.data)) }
+func (i *memInfo) Mode() fs.FileMode          { return i.file.mode }
+func (i *memInfo) ModTime() time.Time         { return i.file.modTime }
+func (i *memInfo) IsDir() bool                { return i.file.mode.IsDir() }
+func (i *memInfo) Sys() any                   { return nil }
+func (i *memInfo) Type() fs.FileMode          { return i.file.mode.Type() }
+func (i *memInfo) Info() (fs.FileInfo, error) { return i, nil }
+
+// syntheticDir stands in for directories without an entry of their own
+var syntheticDir = &memFile{mode: fs.ModeDir | 0o555}
+
+func (m *memFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	file := m.files[name]
+	if file != nil && !file.mode.IsDir() {
+		return &memOpenFile{info: &memInfo{name: path.Base(name), file: file}, Reader: bytes.NewReader(file.data)}, nil
+	}
+	entries, err := m.ReadDir(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	if file == nil {
+		file = syntheticDir
+	}
+	return &memDir{info: &memInfo{name: path.Base(name), file: file}, entries: entries}, nil
+}
+
+// ReadDir lists the entries directly inside the directory name, sorted by
+// name. The list is a copy, which callers may reorder.
+func (m *memFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	entries, ok := m.dirs[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+	return append([]fs.DirEntry(nil), entries...), nil
+}
+
+// memOpenFile is an open regular file of a memFS
+type memOpenFile struct {
+	info *memInfo
+	*bytes.Reader
+}
+
+func (f *memOpenFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *memOpenFile) Close() error               { return nil }
+
+// memDir is an open directory of a memFS
+type memDir struct {
+	info    *memInfo
+	entries []fs.DirEntry
+}
+
+func (d *memDir) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *memDir) Close() error               { return nil }
+func (d *memDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.name, Err: fs.ErrInvalid}
+}
+
+func (d *memDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if n <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	n = min(n, len(d.entries))
+	entries := d.entries[:n]
+	d.entries = d.entries[n:]
+	return entries, nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// metadataFields maps the names accepted by --metadata to the flags they toggle
+var metadataFields = map[string]*bool{
+	"mtime":       &showLastUpdated,
+	"mode":        &showFileMode,
+	"size":        &showFileSize,
+	"disk":        &showDiskUsage,
+	"mime":        &showMimeType,
+	"symlink":     &showSymlinks,
+	"owner":       &showOwnership,
+	"checksum":    &showChecksum,
+	"interpreter": &showInterpreter,
+}
+
+// metadataFieldNames lists the accepted --metadata names in a stable order
+func metadataFieldNames() []string {
+	names := make([]string, 0, len(metadataFields))
+	for name := range metadataFields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// applyMetadataSelection resolves --all-metadata and the --metadata spec into
+// the individual show-* flags. Entries are applied left to right: "all" turns
+// every field on and a leading "-" turns a field off, so "all,-owner" selects
+// everything except ownership.
+func applyMetadataSelection(spec []string) error {
+	if showAllMetadata {
+		for _, enabled := range metadataFields {
+			*enabled = true
+		}
+	}
+	for _, item := range spec {
+		item = strings.TrimSpace(item)
+		value := true
+		if strings.HasPrefix(item, "-") {
+			value = false
+			item = item[1:]
+		}
+		if item == "all" {
+			for _, enabled := range metadataFields {
+				*enabled = value
+			}
+			continue
+		}
+		enabled, ok := metadataFields[item]
+		if !ok {
+			return fmt.Errorf("unknown metadata field %q (valid: all, %s)", item, strings.Join(metadataFieldNames(), ", "))
+		}
+		*enabled = value
+	}
+	return nil
+}
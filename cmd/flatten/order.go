@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// contentOrders lists the values of --order
+var contentOrders = []string{"tree", "breadth", "by-dir"}
+
+// validateOrder checks the --order value
+func validateOrder() error {
+	for _, order := range contentOrders {
+		if contentOrder == order {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid --order value %q (valid: %s)", contentOrder, strings.Join(contentOrders, ", "))
+}
+
+// writeBody writes the contents of the files below root in --order order:
+// depth-first as in the tree, breadth-first, or grouped by directory with a
+// banner above each group
+func writeBody(root *FileEntry, w *strings.Builder, fileHashes map[string]*FileHash, state *renderState) error {
+	switch contentOrder {
+	case "breadth":
+		queue := []*FileEntry{root}
+		for len(queue) > 0 {
+			entry := queue[0]
+			queue = queue[1:]
+			if !entry.IsDir {
+				if err := printFile(entry, w, fileHashes, state, showTokens); err != nil {
+					return err
+				}
+				continue
+			}
+			if printIdenticalDir(entry, w, state) {
+				continue
+			}
+			printDirTokens(entry, w, showTokens)
+			queue = append(queue, entry.Children...)
+		}
+		return nil
+	case "by-dir":
+		state.banners = true
+		return printByDir(root, w, fileHashes, state)
+	}
+	return printFlattenedOutput(root, w, fileHashes, state, showTokens)
+}
+
+// printByDir writes the files directly inside entry, then its subdirectories
+func printByDir(entry *FileEntry, w *strings.Builder, fileHashes map[string]*FileHash, state *renderState) error {
+	if !entry.IsDir {
+		return printFile(entry, w, fileHashes, state, showTokens)
+	}
+	if printIdenticalDir(entry, w, state) {
+		return nil
+	}
+	printDirTokens(entry, w, showTokens)
+	for _, child := range entry.Children {
+		if !child.IsDir {
+			if err := printFile(child, w, fileHashes, state, showTokens); err != nil {
+				return err
+			}
+		}
+	}
+	for _, child := range entry.Children {
+		if child.IsDir {
+			if err := printByDir(child, w, fileHashes, state); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// printBanner writes a directory banner when path lies in a different
+// directory than the previous file
+func printBanner(path string, w *strings.Builder, state *renderState) {
+	dir := filepath.Dir(path)
+	if !state.banners || dir == state.lastDir {
+		return
+	}
+	state.lastDir = dir
+	w.WriteString("\n" + strings.ReplaceAll(label("dir-banner"), "{path}", dir) + "\n")
+}
+
+var readmeFirst bool
+
+// isReadme reports whether name is a README, such as README.md or
+// readme.txt
+func isReadme(name string) bool {
+	lower := strings.ToLower(name)
+	return lower == "readme" || strings.HasPrefix(lower, "readme.")
+}
+
+// elevateReadmes moves the README files of every directory below entry
+// ahead of its other children, as they usually explain what follows
+func elevateReadmes(entry *FileEntry) {
+	readme := func(e *FileEntry) bool {
+		return !e.IsDir && isReadme(filepath.Base(e.Path))
+	}
+	sort.SliceStable(entry.Children, func(i, j int) bool {
+		return readme(entry.Children[i]) && !readme(entry.Children[j])
+	})
+	for _, child := range entry.Children {
+		elevateReadmes(child)
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+)
+
+// Owner and group names are cached by ID, as each lookup may go through NSS
+// and hit LDAP or similar; failed lookups are cached too
+var (
+	ownerNames = make(map[uint32]string)
+	groupNames = make(map[uint32]string)
+)
+
+var (
+	uidMapFile string
+	gidMapFile string
+	uidRanges  []idRange
+	gidRanges  []idRange
+)
+
+// idRange is a line of a --uid-map or --gid-map file: the count IDs from
+// start belong to name, as in /etc/subuid and /etc/subgid
+type idRange struct {
+	name  string
+	start uint32
+	count uint32
+}
+
+// readIDMap parses an ID map file: one "name:start[:count]" per line, where
+// count defaults to 1. Blank lines and lines starting with # are ignored.
+func readIDMap(file string) ([]idRange, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open ID map: %w", err)
+	}
+	defer f.Close()
+	var ranges []idRange
+	scanner := bufio.NewScanner(f)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, ":")
+		if len(fields) < 2 || len(fields) > 3 || fields[0] == "" {
+			return nil, fmt.Errorf("%s:%d: expected name:start[:count], got %q", file, lineNo, line)
+		}
+		start, err := strconv.ParseUint(fields[1], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid start ID %q", file, lineNo, fields[1])
+		}
+		count := uint64(1)
+		if len(fields) == 3 {
+			if count, err = strconv.ParseUint(fields[2], 10, 32); err != nil || count == 0 {
+				return nil, fmt.Errorf("%s:%d: invalid count %q", file, lineNo, fields[2])
+			}
+		}
+		ranges = append(ranges, idRange{name: fields[0], start: uint32(start), count: uint32(count)})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", file, err)
+	}
+	return ranges, nil
+}
+
+// loadIDMaps reads the --uid-map and --gid-map files
+func loadIDMaps() error {
+	var err error
+	if uidMapFile != "" {
+		if uidRanges, err = readIDMap(uidMapFile); err != nil {
+			return err
+		}
+	}
+	if gidMapFile != "" {
+		if gidRanges, err = readIDMap(gidMapFile); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mapID names id by the first range holding it: the name itself at the
+// start of the range, and name+offset past it, e.g. alice+5 for 100005 in
+// alice:100000:65536
+func mapID(ranges []idRange, id uint32) (string, bool) {
+	for _, r := range ranges {
+		if id < r.start || uint64(id) >= uint64(r.start)+uint64(r.count) {
+			continue
+		}
+		if id == r.start {
+			return r.name, true
+		}
+		return fmt.Sprintf("%s+%d", r.name, id-r.start), true
+	}
+	return "", false
+}
+
+// ownerName returns the user name for uid: from --uid-map when it covers
+// uid, then from the system, and the ID itself when neither knows it, as
+// is common in containers. With --numeric-owner it is always the ID.
+func ownerName(uid uint32) string {
+	if numericOwner {
+		return fmt.Sprint(uid)
+	}
+	if name, ok := mapID(uidRanges, uid); ok {
+		return name
+	}
+	if name, ok := ownerNames[uid]; ok {
+		return name
+	}
+	name := fmt.Sprint(uid)
+	if owner, err := user.LookupId(name); err == nil {
+		name = owner.Username
+	}
+	ownerNames[uid] = name
+	return name
+}
+
+// groupName returns the group name for gid, from --gid-map, the system or
+// the ID itself, like ownerName
+func groupName(gid uint32) string {
+	if numericOwner {
+		return fmt.Sprint(gid)
+	}
+	if name, ok := mapID(gidRanges, gid); ok {
+		return name
+	}
+	if name, ok := groupNames[gid]; ok {
+		return name
+	}
+	name := fmt.Sprint(gid)
+	if group, err := user.LookupGroupId(name); err == nil {
+		name = group.Name
+	}
+	groupNames[gid] = name
+	return name
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// porcelainVersion is bumped whenever the porcelain record layout changes.
+// Within a version the layout is frozen, whatever happens to the
+// human-readable output.
+const porcelainVersion = 2
+
+// dirRecords adds a record for each directory to the structured formats
+var dirRecords bool
+
+// porcelainSeparator returns the byte terminating every porcelain field
+func porcelainSeparator() string {
+	if nullSeparated {
+		return "\x00"
+	}
+	return "\n"
+}
+
+// writePorcelainHeader writes the record that opens a porcelain stream:
+//
+//	flatten-porcelain <sep> <version> <sep>
+func writePorcelainHeader(w *strings.Builder) {
+	sep := porcelainSeparator()
+	w.WriteString("flatten-porcelain" + sep + fmt.Sprint(porcelainVersion) + sep)
+}
+
+// writePorcelain writes one root as porcelain records. Every field is
+// terminated by the separator (NUL with --null, newline otherwise):
+//
+//	root <dir>
+//	warning <kind> <path> <message>
+//	dir <path> <mode> <mtime> <children>
+//	file <path> <size> <mode> <mtime> <sha256> <duplicate-of> <link-target> <length> <content>
+//
+// dir records are only written with --dir-records, ahead of the records of
+// the directory's contents; children counts the included entries directly
+// inside it. mtime is in Unix seconds, duplicate-of is empty unless the content was
+// deduplicated, link-target is empty unless the file was reached through a
+// symlink, and length is the byte length of content, or "-" when
+// content is omitted (duplicates and --tree-only). Content is written raw, so
+// parsers must read exactly length bytes rather than scanning for the
+// separator.
+func writePorcelain(w *strings.Builder, dir string, root *FileEntry, fileHashes map[string]*FileHash) error {
+	sep := porcelainSeparator()
+	w.WriteString("root" + sep + dir + sep)
+	return writePorcelainEntry(w, root, fileHashes, sep)
+}
+
+func writePorcelainEntry(w *strings.Builder, entry *FileEntry, fileHashes map[string]*FileHash, sep string) error {
+	return eachRecord(entry, fileHashes, func(dir *FileEntry) {
+		for _, field := range append([]string{"dir"}, dirFields(dir)...) {
+			w.WriteString(field + sep)
+		}
+	}, func(rec *fileRecord) {
+		for _, field := range append([]string{"file"}, rec.fields()...) {
+			w.WriteString(field + sep)
+		}
+		if rec.omitted {
+			w.WriteString("-" + sep)
+			return
+		}
+		w.WriteString(fmt.Sprint(len(rec.content)) + sep)
+		w.Write(rec.content)
+		w.WriteString(sep)
+	})
+}
+
+// fileRecord is what the structured formats record about a file
+type fileRecord struct {
+	entry       *FileEntry
+	hash        string
+	duplicateOf string
+	content     []byte
+	// omitted is set when content is left out (duplicates and --tree-only)
+	omitted bool
+}
+
+// fields returns the metadata fields of the record, in stream order
+func (rec *fileRecord) fields() []string {
+	return []string{
+		rec.entry.Path,
+		fmt.Sprint(rec.entry.Size),
+		rec.entry.Mode.String(),
+		fmt.Sprint(rec.entry.ModTime),
+		rec.hash,
+		rec.duplicateOf,
+		rec.entry.LinkTarget,
+	}
+}
+
+// dirFields returns the fields of the record of a directory, in stream order
+func dirFields(entry *FileEntry) []string {
+	return []string{
+		entry.Path,
+		entry.Mode.String(),
+		fmt.Sprint(entry.ModTime),
+		fmt.Sprint(len(entry.Children)),
+	}
+}
+
+// eachRecord calls emit for every readable file below entry, deduplicating
+// contents through fileHashes, and with --dir-records emitDir for every
+// directory before its contents. Content is only read when it is emitted.
+func eachRecord(entry *FileEntry, fileHashes map[string]*FileHash, emitDir func(dir *FileEntry), emit func(rec *fileRecord)) error {
+	if entry.IsDir {
+		if dirRecords && !interrupted() {
+			emitDir(entry)
+		}
+		for _, child := range entry.Children {
+			if err := eachRecord(child, fileHashes, emitDir, emit); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if interrupted() {
+		return nil
+	}
+	hash, err := entry.Hash()
+	if err != nil {
+		warnReadError(entry.Path, err)
+		return nil
+	}
+	rec := &fileRecord{entry: entry, hash: hash}
+	if !noFileDeduplication {
+		if existing, exists := fileHashes[hash]; exists {
+			rec.duplicateOf = existing.Path
+		}
+	}
+	rec.omitted = rec.duplicateOf != "" || treeOnly
+	if !rec.omitted {
+		if rec.content, err = entry.ReadContent(); err != nil {
+			warnReadError(entry.Path, err)
+			return nil
+		}
+		// The file changed after it was hashed; keep the hash true to the
+		// content
+		if entry.changed {
+			rec.hash = calculateFileHash(rec.content)
+		}
+	}
+	if rec.duplicateOf == "" && !noFileDeduplication {
+		fileHashes[rec.hash] = &FileHash{Path: entry.Path, Hash: rec.hash}
+	}
+	filesWritten++
+	emit(rec)
+	return nil
+}
+
+// writePorcelainWarnings writes a warning record for each warning of the run
+func writePorcelainWarnings(w *strings.Builder) {
+	sep := porcelainSeparator()
+	for _, warning := range runWarnings {
+		w.WriteString("warning" + sep + warning.Kind + sep + warning.Path + sep + warning.Message + sep)
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/pkoukk/tiktoken-go"
+)
+
+var previewFilters bool
+
+// patternPreview is a row of --preview-filters: a pattern and what it
+// matches on its own
+type patternPreview struct {
+	kind    string
+	pattern string
+	matches func(entry *FileEntry) bool
+	files   int
+	size    int64
+	tokens  int
+}
+
+// previewRows builds a row for every include, exclude and content pattern
+// of the run
+func previewRows() []*patternPreview {
+	var rows []*patternPreview
+	matchName := func(patterns ...string) func(entry *FileEntry) bool {
+		return func(entry *FileEntry) bool {
+			for _, pattern := range patterns {
+				if matchPattern(pattern, entry.name) {
+					return true
+				}
+			}
+			return false
+		}
+	}
+	for _, pattern := range includePatterns {
+		rows = append(rows, &patternPreview{kind: "include", pattern: pattern, matches: matchName(pattern)})
+	}
+	if len(extensions) > 0 {
+		for _, pattern := range extensionPatterns(extensions)[len(wellKnownFiles):] {
+			rows = append(rows, &patternPreview{kind: "include (--ext)", pattern: pattern, matches: matchName(pattern)})
+		}
+		rows = append(rows, &patternPreview{kind: "include (--ext)", pattern: "well-known files", matches: matchName(wellKnownFiles...)})
+	}
+	for _, pattern := range excludePatterns {
+		if dir, ok := strings.CutSuffix(pattern, "/"); ok {
+			rows = append(rows, &patternPreview{kind: "exclude dir", pattern: pattern, matches: func(entry *FileEntry) bool {
+				return strings.HasPrefix(entry.name, dir+"/")
+			}})
+			continue
+		}
+		rows = append(rows, &patternPreview{kind: "exclude", pattern: pattern, matches: matchName(pattern)})
+	}
+	for i, re := range contentPatterns {
+		re := re
+		rows = append(rows, &patternPreview{kind: "exclude content", pattern: excludeContent[i], matches: func(entry *FileEntry) bool {
+			head, err := entry.ReadHead(contentHead)
+			return err == nil && re.Match(head)
+		}})
+	}
+	return rows
+}
+
+// countPreview adds the files below entry to the rows they match
+func countPreview(entry *FileEntry, rows []*patternPreview) int {
+	if entry.IsDir {
+		files := 0
+		for _, child := range entry.Children {
+			files += countPreview(child, rows)
+		}
+		return files
+	}
+	for _, row := range rows {
+		if row.matches(entry) {
+			row.files++
+			row.size += entry.Size
+			row.tokens += entry.Tokens
+		}
+	}
+	return 1
+}
+
+// previewPatterns prints, for each directory, how many files and bytes each
+// pattern of the run matches on its own among the files the other filters
+// keep, so dead or overly broad patterns in a shared config stand out
+func previewPatterns(dirs []string, tokenizer *tiktoken.Tiktoken) error {
+	opts := filterOptions()
+	opts.IncludePatterns = nil
+	opts.ExcludePatterns = nil
+	opts.ExcludeContent = nil
+	var output strings.Builder
+	for _, dir := range dirs {
+		filter, err := NewFilter(dir, opts)
+		if err != nil {
+			return fmt.Errorf("failed to create filter for %s: %w", dir, err)
+		}
+		realRoot, err := realPath(dir)
+		if err != nil {
+			return fmt.Errorf("failed to resolve %s: %w", dir, err)
+		}
+		root, err := loadDirectory(&walkState{
+			fsys:      os.DirFS(dir),
+			root:      dir,
+			realRoot:  realRoot,
+			filter:    filter,
+			tokenizer: tokenizer,
+			limits:    &walkLimits{},
+		}, ".")
+		if err != nil {
+			return fmt.Errorf("failed to load directory structure for %s: %w", dir, err)
+		}
+		rows := previewRows()
+		files := 0
+		if root != nil {
+			files = countPreview(root, rows)
+		}
+		output.WriteString(fmt.Sprintf("%s: each pattern on its own, among %d files the other filters keep\n", dir, files))
+		if len(rows) == 0 {
+			output.WriteString("no include or exclude patterns\n\n")
+			continue
+		}
+		writePreviewTable(&output, rows)
+		output.WriteString("\n")
+	}
+	fmt.Print(output.String())
+	return nil
+}
+
+// writePreviewTable writes the rows as aligned columns, flagging patterns
+// that match nothing
+func writePreviewTable(w *strings.Builder, rows []*patternPreview) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	header := "KIND\tPATTERN\tFILES\tSIZE"
+	if showTokens {
+		header += "\tTOKENS"
+	}
+	fmt.Fprintln(tw, header+"\t")
+	for _, row := range rows {
+		line := fmt.Sprintf("%s\t%s\t%d\t%s", row.kind, row.pattern, row.files, formatBytes(row.size))
+		if showTokens {
+			line += fmt.Sprintf("\t%d", row.tokens)
+		}
+		note := ""
+		if row.files == 0 {
+			note = "unused"
+		}
+		fmt.Fprintln(tw, line+"\t"+note)
+	}
+	tw.Flush()
+}
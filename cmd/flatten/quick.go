@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+var quick bool
+
+// --quick trades completeness for latency, for editor integrations that
+// want an answer in about two seconds
+const (
+	// quickTimeout bounds the walk, leaving time to write the output
+	quickTimeout = 1500 * time.Millisecond
+	// quickDepth is how many levels of directories are walked below each
+	// root; deeper directories appear in the tree without their contents
+	quickDepth = 3
+	// quickFileBytes is how much of each file's content is shown
+	quickFileBytes = 8 << 10
+)
+
+// quickDefaults are the options --quick sets, unless given explicitly on the
+// command line or in .flatten.yaml: no hashing for deduplication, and the
+// files closest to the root, READMEs first, ahead of the rest
+var quickDefaults = []struct{ flag, value string }{
+	{"timeout", quickTimeout.String()},
+	{"no-dedup", "true"},
+	{"order", "breadth"},
+	{"readme-first", "true"},
+}
+
+// applyQuick sets the options of --quick that weren't set otherwise
+func applyQuick(flags *pflag.FlagSet) error {
+	if !quick {
+		return nil
+	}
+	for _, d := range quickDefaults {
+		flag := flags.Lookup(d.flag)
+		if flag.Changed || flag.Value.String() != flag.DefValue {
+			continue
+		}
+		// Set leaves Changed alone, so the header lists --quick rather
+		// than the options it implies
+		if err := flag.Value.Set(d.value); err != nil {
+			return fmt.Errorf("--quick: %w", err)
+		}
+	}
+	return nil
+}
+
+// readShown reads the content printFile needs: under --quick only as much as
+// can be shown, unless deduplication, checksums, file IDs or a summary need
+// the whole file. It reports whether the file was read in full.
+func readShown(entry *FileEntry) ([]byte, bool, error) {
+	whole := !noFileDeduplication || showChecksum || fileIDs ||
+		summarizeOverBytes > 0 && entry.Size > summarizeOverBytes
+	if !quick || whole {
+		content, err := entry.ReadContent()
+		return content, true, err
+	}
+	// One byte more than is shown tells quickHead the file goes on
+	content, err := entry.ReadHead(quickFileBytes + 1)
+	return content, int64(len(content)) >= entry.Size, err
+}
+
+// quickHead cuts content to quickFileBytes, at the last line break when
+// there is one, and reports whether it was cut
+func quickHead(content []byte) ([]byte, bool) {
+	if !quick || len(content) <= quickFileBytes {
+		return content, false
+	}
+	head := content[:quickFileBytes]
+	for i := len(head) - 1; i > 0; i-- {
+		if head[i] == '\n' {
+			return head[:i], true
+		}
+	}
+	return head, true
+}
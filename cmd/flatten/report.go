@@ -0,0 +1,90 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+var reportPath string
+
+// runReport is the --report artifact: everything about a run in one JSON
+// document that CI can archive and compare between builds
+type runReport struct {
+	Version     string       `json:"version"`
+	Options     []string     `json:"options"`
+	Started     string       `json:"started"`
+	DurationMs  int64        `json:"duration_ms"`
+	Roots       []rootReport `json:"roots"`
+	Truncated   bool         `json:"truncated"`
+	Interrupted bool         `json:"interrupted"`
+	Warnings    []Warning    `json:"warnings"`
+	Output      outputReport `json:"output"`
+}
+
+// rootReport describes one flattened directory of the run
+type rootReport struct {
+	Dir      string         `json:"dir"`
+	Files    int            `json:"files"`
+	Size     int64          `json:"size"`
+	Excluded map[string]int `json:"excluded,omitempty"`
+	// WalkMs covers loading and annotating the tree, RenderMs writing it
+	WalkMs   int64 `json:"walk_ms"`
+	RenderMs int64 `json:"render_ms"`
+}
+
+// outputReport identifies what the run wrote to stdout
+type outputReport struct {
+	Format string `json:"format"`
+	Size   int    `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// newRootReport summarizes a rendered root and the time spent on it
+func newRootReport(dir string, root *FileEntry, filter *Filter, walk time.Duration, render time.Duration) rootReport {
+	return rootReport{
+		Dir:      dir,
+		Files:    getTotalFiles(root),
+		Size:     getTotalSize(root),
+		Excluded: filter.Exclusions(),
+		WalkMs:   walk.Milliseconds(),
+		RenderMs: render.Milliseconds(),
+	}
+}
+
+// writeReport completes the report with the run's warnings and output, and
+// writes it as JSON
+func writeReport(path string, report runReport, started time.Time, output string) error {
+	report.Version = flattenVersion()
+	report.Options = runOptions
+	if report.Options == nil {
+		report.Options = []string{}
+	}
+	if report.Roots == nil {
+		report.Roots = []rootReport{}
+	}
+	report.Started = started.UTC().Format(time.RFC3339)
+	report.DurationMs = time.Since(started).Milliseconds()
+	report.Interrupted = interrupted()
+	report.Warnings = runWarnings
+	if report.Warnings == nil {
+		report.Warnings = []Warning{}
+	}
+	format := outputFormat
+	if listOnly {
+		format = "list"
+	}
+	sum := sha256.Sum256([]byte(output))
+	report.Output = outputReport{Format: format, Size: len(output), SHA256: hex.EncodeToString(sum[:])}
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode report: %w", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("failed to write report %s: %w", path, err)
+	}
+	return nil
+}
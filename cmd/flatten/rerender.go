@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// snapshotRoot is one root of a snapshot, rebuilt as an in-memory file system
+type snapshotRoot struct {
+	dir   string
+	files map[string]*memFile
+	// links holds the recorded symlink targets by path
+	links map[string]string
+}
+
+// readSnapshot rebuilds the roots of a porcelain or fbin stream. Duplicates
+// take the content of the file they duplicate; files whose content the
+// snapshot omitted altogether (--tree-only) are skipped with a warning.
+func readSnapshot(r io.Reader) ([]*snapshotRoot, error) {
+	sr, err := openSnapshot(r)
+	if err != nil {
+		return nil, err
+	}
+	var roots []*snapshotRoot
+	contents := make(map[string][]byte)
+	for {
+		rec, err := sr.next()
+		if err == io.EOF {
+			return roots, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(roots) == 0 || roots[len(roots)-1].dir != rec.root {
+			roots = append(roots, &snapshotRoot{dir: rec.root, files: make(map[string]*memFile), links: make(map[string]string)})
+		}
+		current := roots[len(roots)-1]
+		rel, err := filepath.Rel(current.dir, rec.path)
+		if err != nil || !filepath.IsLocal(rel) {
+			return nil, fmt.Errorf("unsafe path %q: not under its root %q", rec.path, current.dir)
+		}
+		if rec.dir {
+			// Directory records keep empty directories and directory
+			// metadata, which are otherwise synthesized
+			mode, err := parseFileMode(rec.mode)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", rec.path, err)
+			}
+			current.files[filepath.ToSlash(rel)] = &memFile{mode: fs.ModeDir | mode, modTime: time.Unix(rec.modTime, 0)}
+			continue
+		}
+		content := rec.content
+		if content == nil {
+			var ok bool
+			if content, ok = contents[rec.duplicateOf]; rec.duplicateOf == "" || !ok {
+				warn(warnUnreadable, rec.path, "content not included in the snapshot; skipped")
+				continue
+			}
+		}
+		contents[rec.path] = content
+		mode, err := parseFileMode(rec.mode)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", rec.path, err)
+		}
+		current.files[filepath.ToSlash(rel)] = &memFile{data: content, mode: mode, modTime: time.Unix(rec.modTime, 0)}
+		if rec.linkTarget != "" {
+			current.links[rec.path] = rec.linkTarget
+		}
+	}
+}
+
+// restoreLinks puts the recorded symlink targets back on the loaded entries
+func restoreLinks(entry *FileEntry, links map[string]string) {
+	entry.LinkTarget = links[entry.Path]
+	for _, child := range entry.Children {
+		restoreLinks(child, links)
+	}
+}
+
+// rerender renders the roots of a snapshot in the current output format.
+// The snapshot already reflects the selection of the run that wrote it, so
+// ignore files and binary detection aren't applied again; -I and -E can
+// still narrow it.
+func rerender(w *strings.Builder, roots []*snapshotRoot) error {
+	opts := filterOptions()
+	opts.IncludeGitIgnore = true
+	opts.IncludeBin = true
+	fileHashes := make(map[string]*FileHash)
+	writeFormatHeader(w)
+	for _, sr := range roots {
+		fsys := newMemFS(sr.files)
+		filter := NewFSFilter(fsys, sr.dir, opts)
+		root, err := loadDirectory(&walkState{
+			fsys:   fsys,
+			root:   sr.dir,
+			filter: filter,
+			limits: &walkLimits{},
+		}, ".")
+		if err != nil {
+			return fmt.Errorf("failed to load snapshot of %s: %w", sr.dir, err)
+		}
+		if root == nil {
+			continue
+		}
+		restoreLinks(root, sr.links)
+		if err := renderRoot(w, sr.dir, root, filter, fileHashes); err != nil {
+			return err
+		}
+	}
+	writeFormatWarnings(w)
+	return nil
+}
+
+var rerenderCmd = &cobra.Command{
+	Use:   "rerender <snapshot>",
+	Short: "Render a stored porcelain or fbin snapshot in another output format",
+	Long: `Rerender reads a snapshot written with --porcelain or --format fbin (from
+a file, or stdin when the snapshot is "-") and renders it again in the
+--format given, without walking the file system. Contents, modes, mtimes
+and symlink targets come from the snapshot.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := resolveFormat(); err != nil {
+			return err
+		}
+		runOptions = usedOptions(cmd.Flags())
+		if nullSeparated && !porcelain {
+			return fmt.Errorf("--null requires --format porcelain")
+		}
+		var in io.Reader = os.Stdin
+		if args[0] != "-" {
+			file, err := os.Open(args[0])
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			in = file
+		}
+		roots, err := readSnapshot(in)
+		if err != nil {
+			return fmt.Errorf("failed to read snapshot %s: %w", args[0], err)
+		}
+		var output strings.Builder
+		if err := rerender(&output, roots); err != nil {
+			return err
+		}
+		fmt.Print(output.String())
+		return nil
+	},
+}
+
+func init() {
+	rerenderCmd.Flags().StringVar(&outputFormat, "format", "markdown", "Output format: markdown, porcelain, or fbin")
+	rerenderCmd.Flags().BoolVarP(&nullSeparated, "null", "0", false, "Terminate porcelain fields with NUL instead of newline")
+	rerenderCmd.Flags().BoolVar(&dirRecords, "dir-records", false, "Also emit a record for each directory in the porcelain and fbin formats")
+	rerenderCmd.Flags().StringSliceVarP(&includePatterns, "include", "I", []string{}, "Include only files matching these patterns (e.g. '*.go,*.js')")
+	rerenderCmd.Flags().StringSliceVarP(&excludePatterns, "exclude", "E", []string{}, "Exclude files matching these patterns (e.g. '*.test.js')")
+	rootCmd.AddCommand(rerenderCmd)
+}
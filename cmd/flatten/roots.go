@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// rootLabel is a friendly name given to a flattened directory with --label
+type rootLabel struct {
+	name string
+	dir  string
+}
+
+var rootLabelSpecs []string
+
+// parseRootLabels reads the --label values, each in the form name=path
+func parseRootLabels() ([]rootLabel, error) {
+	labels := make([]rootLabel, 0, len(rootLabelSpecs))
+	for _, spec := range rootLabelSpecs {
+		name, dir, ok := strings.Cut(spec, "=")
+		if !ok || name == "" || dir == "" {
+			return nil, fmt.Errorf("invalid --label %q (expected name=path)", spec)
+		}
+		labels = append(labels, rootLabel{name: name, dir: filepath.Clean(dir)})
+	}
+	return labels, nil
+}
+
+// labelledRoots appends the labelled directories that aren't among the
+// directory arguments, so `--label api=services/api` alone flattens
+// services/api
+func labelledRoots(args []string, labels []rootLabel) []string {
+	for _, label := range labels {
+		if !containsRoot(args, label.dir) {
+			args = append(args, label.dir)
+		}
+	}
+	return args
+}
+
+func containsRoot(args []string, dir string) bool {
+	for _, arg := range args {
+		if filepath.Clean(arg) == dir {
+			return true
+		}
+	}
+	return false
+}
+
+// rootName returns the label of dir, or dir itself when it has none
+func rootName(dir string, labels []rootLabel) string {
+	for _, label := range labels {
+		if label.dir == filepath.Clean(dir) {
+			return label.name
+		}
+	}
+	return dir
+}
+
+// relabel replaces the directory prefix of every path below entry with name
+func relabel(entry *FileEntry, dir string, name string) {
+	if rel, err := filepath.Rel(dir, entry.Path); err == nil {
+		entry.Path = filepath.Join(name, rel)
+	}
+	for _, child := range entry.Children {
+		relabel(child, dir, name)
+	}
+}
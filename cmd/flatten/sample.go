@@ -0,0 +1,126 @@
+package main
+
+import (
+	"hash/fnv"
+	"path"
+	"sort"
+)
+
+// sampleStratum is the files of a directory sharing an extension
+type sampleStratum struct {
+	key   string
+	files []*FileEntry
+}
+
+// sampleRank orders the files of a stratum pseudo-randomly, but the same way
+// on every run, so a sample is reproducible
+func sampleRank(entry *FileEntry) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(entry.name))
+	return h.Sum64()
+}
+
+// collectStrata groups the files below entry by directory and extension
+func collectStrata(entry *FileEntry, strata map[string]*sampleStratum) {
+	if !entry.IsDir {
+		key := path.Dir(entry.name) + "\x00" + path.Ext(entry.name)
+		if strata[key] == nil {
+			strata[key] = &sampleStratum{key: key}
+		}
+		strata[key].files = append(strata[key].files, entry)
+		return
+	}
+	for _, child := range entry.Children {
+		collectStrata(child, strata)
+	}
+}
+
+// sampleQuotas splits n files among the strata: one each while they last,
+// largest strata first, and the rest in proportion to what is left of each,
+// by largest remainder
+func sampleQuotas(strata []*sampleStratum, n int) []int {
+	quotas := make([]int, len(strata))
+	order := make([]int, len(strata))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return len(strata[order[a]].files) > len(strata[order[b]].files)
+	})
+	for _, i := range order {
+		if n == 0 {
+			return quotas
+		}
+		quotas[i] = 1
+		n--
+	}
+	rest := 0
+	for _, s := range strata {
+		rest += len(s.files) - 1
+	}
+	if n == 0 || rest == 0 {
+		return quotas
+	}
+	remainders := make([]int, len(strata))
+	given := 0
+	for i, s := range strata {
+		share := n * (len(s.files) - 1)
+		quotas[i] += share / rest
+		remainders[i] = share % rest
+		given += share / rest
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return remainders[order[a]] > remainders[order[b]]
+	})
+	for _, i := range order[:n-given] {
+		quotas[i]++
+	}
+	return quotas
+}
+
+// sampleTree prunes root to a sample of n files, stratified by
+// directory and extension so every corner of the tree is represented.
+// Directories left without files are dropped, and the files left out are
+// counted as excluded by sampling.
+func sampleTree(root *FileEntry, n int, filter *Filter) {
+	strataByKey := make(map[string]*sampleStratum)
+	collectStrata(root, strataByKey)
+	strata := make([]*sampleStratum, 0, len(strataByKey))
+	total := 0
+	for _, s := range strataByKey {
+		strata = append(strata, s)
+		total += len(s.files)
+	}
+	if total <= n {
+		return
+	}
+	sort.Slice(strata, func(i, j int) bool { return strata[i].key < strata[j].key })
+	kept := make(map[*FileEntry]bool)
+	for i, quota := range sampleQuotas(strata, n) {
+		files := strata[i].files
+		sort.Slice(files, func(a, b int) bool { return sampleRank(files[a]) < sampleRank(files[b]) })
+		for _, entry := range files[:quota] {
+			kept[entry] = true
+		}
+	}
+	pruneSample(root, kept, filter)
+}
+
+// pruneSample removes the files not kept, and the directories emptied by it,
+// reporting whether anything is left below entry
+func pruneSample(entry *FileEntry, kept map[*FileEntry]bool, filter *Filter) bool {
+	if !entry.IsDir {
+		if !kept[entry] {
+			filter.CountExclusion(ReasonSampled)
+		}
+		return kept[entry]
+	}
+	children := entry.Children[:0]
+	for _, child := range entry.Children {
+		if pruneSample(child, kept, filter) {
+			children = append(children, child)
+		}
+	}
+	entry.Children = children
+	return len(children) > 0
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// FileMetadata is the machine-readable description of a file written to the
+// --sidecar file
+type FileMetadata struct {
+	Path        string `json:"path"`
+	Size        int64  `json:"size"`
+	DiskUsage   int64  `json:"disk_usage"`
+	Mode        string `json:"mode"`
+	ModTime     string `json:"mod_time"`
+	MimeType    string `json:"mime_type"`
+	SHA256      string `json:"sha256"`
+	Tokens      int    `json:"tokens,omitempty"`
+	GitStatus   string `json:"git_status,omitempty"`
+	Interpreter string `json:"interpreter,omitempty"`
+	DuplicateOf string `json:"duplicate_of,omitempty"`
+}
+
+// collectMetadata appends the metadata of every file under entry to files.
+// seen maps content hashes to the first path they were found at, so
+// duplicates can point back to it.
+func collectMetadata(entry *FileEntry, files []FileMetadata, seen map[string]string) ([]FileMetadata, error) {
+	if entry.IsDir {
+		var err error
+		for _, child := range entry.Children {
+			files, err = collectMetadata(child, files, seen)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return files, nil
+	}
+	hash, err := entry.Hash()
+	if err != nil {
+		warnReadError(entry.Path, err)
+		return files, nil
+	}
+	mimeType, err := mimeTypeOf(entry, func() ([]byte, error) { return entry.ReadHead(sniffLen) })
+	if err != nil {
+		warnReadError(entry.Path, err)
+		return files, nil
+	}
+	meta := FileMetadata{
+		Path:      entry.Path,
+		Size:      entry.Size,
+		DiskUsage: entry.DiskUsage,
+		Mode:      entry.Mode.String(),
+		ModTime:   time.Unix(entry.ModTime, 0).UTC().Format(time.RFC3339),
+		MimeType:  mimeType,
+		SHA256:    hash,
+		Tokens:    entry.Tokens,
+		GitStatus: entry.GitStatus,
+	}
+	if entry.Mode.Perm()&0o111 != 0 {
+		head, err := entry.ReadHead(sniffLen)
+		if err != nil {
+			warnReadError(entry.Path, err)
+			return files, nil
+		}
+		meta.Interpreter = interpreterOf(entry, head)
+	}
+	if first, ok := seen[hash]; ok {
+		meta.DuplicateOf = first
+	} else {
+		seen[hash] = entry.Path
+	}
+	return append(files, meta), nil
+}
+
+// writeSidecar writes the collected file metadata as a JSON array
+func writeSidecar(path string, files []FileMetadata) error {
+	if files == nil {
+		files = []FileMetadata{}
+	}
+	data, err := json.MarshalIndent(files, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode sidecar: %w", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("failed to write sidecar %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+// version is the flatten release, set at build time with
+// -ldflags "-X main.version=v1.2.3"; without it the module version is used
+var version string
+
+// checksumAlgorithm is the algorithm behind the sha256 fields and file IDs
+const checksumAlgorithm = "sha256"
+
+var reproducible bool
+
+// runOptions are the options of the run, as recorded in the header
+var runOptions []string
+
+// flattenVersion returns the version of this build, "(devel)" for builds
+// from a checkout
+func flattenVersion() string {
+	if version != "" {
+		return version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" {
+		return info.Main.Version
+	}
+	return "(devel)"
+}
+
+// usedOptions lists the flags set on the command line or by .flatten.yaml,
+// in name order. Options that others imply, such as those of --quick or
+// --metadata, are left out: the options that imply them are listed.
+func usedOptions(flags *pflag.FlagSet) []string {
+	var options []string
+	flags.VisitAll(func(f *pflag.Flag) {
+		if !f.Changed {
+			return
+		}
+		value := f.Value.String()
+		if f.Value.Type() == "bool" && value == "true" {
+			options = append(options, "--"+f.Name)
+			return
+		}
+		if _, ok := f.Value.(pflag.SliceValue); ok || f.Value.Type() == "stringToString" {
+			value = strings.TrimSuffix(strings.TrimPrefix(value, "["), "]")
+		}
+		if strings.ContainsAny(value, " \t\n'\"\\$`*?[]{}|&;<>()#~") {
+			value = shellQuote(value)
+		}
+		options = append(options, "--"+f.Name+"="+value)
+	})
+	return options
+}
+
+// writeStamp writes what produced the output, so a snapshot found months
+// later describes itself. --reproducible leaves out the time, the only part
+// that differs between runs over the same tree.
+func writeStamp(w *strings.Builder) {
+	w.WriteString(fmt.Sprintf("%s: %s\n", label("flatten"), flattenVersion()))
+	if !reproducible {
+		w.WriteString(fmt.Sprintf("- %s: %s\n", label("generated"), time.Now().UTC().Format(time.RFC3339)))
+	}
+	if len(runOptions) > 0 {
+		w.WriteString(fmt.Sprintf("- %s: %s\n", label("options"), strings.Join(runOptions, " ")))
+	}
+	// Deduplication keys never appear in the output; the algorithm is only
+	// stated for the checksums and IDs that do
+	if showChecksum || fileIDs {
+		w.WriteString(fmt.Sprintf("- %s: %s\n", label("checksum-algorithm"), checksumAlgorithm))
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// suggestLargeFile is the size above which a single file is suggested
+const suggestLargeFile = 512 * 1024
+
+// generatedDirs are directory names that usually hold generated or vendored
+// files
+var generatedDirs = map[string]bool{
+	"node_modules": true, "vendor": true, "dist": true, "build": true,
+	"out": true, "target": true, "coverage": true, ".next": true,
+	".nuxt": true, ".venv": true, "venv": true, "__pycache__": true,
+	".terraform": true, ".gradle": true, ".cache": true,
+}
+
+// noisyPatterns are files that are rarely worth reading, with the reason
+var noisyPatterns = []struct {
+	pattern string
+	reason  string
+}{
+	{"package-lock.json", "lock file"},
+	{"yarn.lock", "lock file"},
+	{"pnpm-lock.yaml", "lock file"},
+	{"Cargo.lock", "lock file"},
+	{"poetry.lock", "lock file"},
+	{"composer.lock", "lock file"},
+	{"Gemfile.lock", "lock file"},
+	{"go.sum", "checksum list"},
+	{"*.min.js", "minified"},
+	{"*.min.css", "minified"},
+	{"*.map", "source map"},
+}
+
+// ignoreSuggestion is a proposed .flattenignore entry and what it matches
+type ignoreSuggestion struct {
+	pattern string
+	reason  string
+	files   int
+	size    int64
+}
+
+// ignoreSuggester gathers suggestions while walking a loaded tree
+type ignoreSuggester struct {
+	filter      *Filter
+	suggestions map[string]*ignoreSuggestion
+}
+
+func (s *ignoreSuggester) suggest(pattern string, reason string, files int, size int64) {
+	sug, ok := s.suggestions[pattern]
+	if !ok {
+		sug = &ignoreSuggestion{pattern: pattern, reason: reason}
+		s.suggestions[pattern] = sug
+	}
+	sug.files += files
+	sug.size += size
+}
+
+// visit looks for generated directories, noisy and large files, and
+// binaries, which are suggested by extension
+func (s *ignoreSuggester) visit(entry *FileEntry) {
+	if entry.IsDir {
+		if entry.name != "." && generatedDirs[path.Base(entry.name)] {
+			s.suggest(path.Base(entry.name)+"/", "generated or vendored directory", getTotalFiles(entry), getTotalSize(entry))
+			return
+		}
+		for _, child := range entry.Children {
+			s.visit(child)
+		}
+		return
+	}
+	base := path.Base(entry.name)
+	for _, noisy := range noisyPatterns {
+		if matched, _ := path.Match(noisy.pattern, base); matched {
+			s.suggest(noisy.pattern, noisy.reason, 1, entry.Size)
+			return
+		}
+	}
+	if binary, err := s.filter.isBinaryFile(entry.Path); err == nil && binary {
+		if ext := path.Ext(base); ext != "" {
+			s.suggest("*"+ext, "binary", 1, entry.Size)
+		} else {
+			s.suggest("/"+entry.name, "binary", 1, entry.Size)
+		}
+		return
+	}
+	if entry.Size >= suggestLargeFile {
+		s.suggest("/"+entry.name, "large file", 1, entry.Size)
+	}
+}
+
+// suggestIgnores walks dir with the default filters, binaries included, and
+// returns the suggested entries, largest first
+func suggestIgnores(dir string) ([]*ignoreSuggestion, error) {
+	opts := filterOptions()
+	opts.IncludeBin = true
+	filter, err := NewFilter(dir, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create filter for %s: %w", dir, err)
+	}
+	realRoot, err := realPath(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s: %w", dir, err)
+	}
+	root, err := loadDirectory(&walkState{
+		fsys:     os.DirFS(dir),
+		root:     dir,
+		realRoot: realRoot,
+		filter:   filter,
+		limits:   &walkLimits{},
+	}, ".")
+	if err != nil {
+		return nil, fmt.Errorf("failed to load directory structure for %s: %w", dir, err)
+	}
+	s := &ignoreSuggester{filter: filter, suggestions: make(map[string]*ignoreSuggestion)}
+	if root != nil {
+		s.visit(root)
+	}
+	var list []*ignoreSuggestion
+	for _, sug := range s.suggestions {
+		list = append(list, sug)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].size != list[j].size {
+			return list[i].size > list[j].size
+		}
+		return list[i].pattern < list[j].pattern
+	})
+	return list, nil
+}
+
+// appendIgnores adds the patterns missing from the .flattenignore of dir
+// and returns how many were added
+func appendIgnores(dir string, suggestions []*ignoreSuggestion) (int, error) {
+	file := filepath.Join(dir, ".flattenignore")
+	existing := make(map[string]bool)
+	data, err := os.ReadFile(file)
+	if err != nil && !os.IsNotExist(err) {
+		return 0, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		existing[strings.TrimSpace(line)] = true
+	}
+	var sb strings.Builder
+	if len(data) > 0 && !strings.HasSuffix(string(data), "\n") {
+		sb.WriteString("\n")
+	}
+	added := 0
+	for _, sug := range suggestions {
+		if existing[sug.pattern] {
+			continue
+		}
+		sb.WriteString(fmt.Sprintf("# %s\n%s\n", sug.reason, sug.pattern))
+		added++
+	}
+	if added == 0 {
+		return 0, nil
+	}
+	f, err := os.OpenFile(file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := f.WriteString(sb.String()); err != nil {
+		f.Close()
+		return 0, err
+	}
+	return added, f.Close()
+}
+
+var suggestWrite bool
+
+var suggestIgnoresCmd = &cobra.Command{
+	Use:   "suggest-ignores [directory]",
+	Short: "Propose .flattenignore entries for generated, large and binary files",
+	Long: `Suggest-ignores walks the directory with the default filters and proposes
+.flattenignore entries for generated or vendored directories, lock files,
+minified files, binaries and large files, largest first. With --write they
+are appended to the directory's .flattenignore.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dir := "."
+		if len(args) > 0 {
+			dir = args[0]
+		}
+		suggestions, err := suggestIgnores(dir)
+		if err != nil {
+			return err
+		}
+		var output strings.Builder
+		for _, sug := range suggestions {
+			output.WriteString(fmt.Sprintf("# %s: %d files, %s\n%s\n", sug.reason, sug.files, formatBytes(sug.size), sug.pattern))
+		}
+		fmt.Print(output.String())
+		if suggestWrite {
+			added, err := appendIgnores(dir, suggestions)
+			if err != nil {
+				return fmt.Errorf("failed to write .flattenignore: %w", err)
+			}
+			fmt.Fprintf(os.Stderr, "added %d entries to %s\n", added, filepath.Join(dir, ".flattenignore"))
+		}
+		return nil
+	},
+}
+
+func init() {
+	suggestIgnoresCmd.Flags().BoolVar(&suggestWrite, "write", false, "Append the suggestions to the directory's .flattenignore")
+	rootCmd.AddCommand(suggestIgnoresCmd)
+}
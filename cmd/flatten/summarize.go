@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// byteUnits are the suffixes accepted by parseByteSize; like formatBytes,
+// they are powers of 1024
+var byteUnits = []struct {
+	suffix string
+	size   int64
+}{
+	{"GB", 1 << 30}, {"G", 1 << 30},
+	{"MB", 1 << 20}, {"M", 1 << 20},
+	{"KB", 1 << 10}, {"K", 1 << 10},
+	{"B", 1},
+}
+
+// parseByteSize parses sizes such as "200KB", "1.5M" or "4096"
+func parseByteSize(s string) (int64, error) {
+	number, unit := strings.TrimSpace(s), int64(1)
+	upper := strings.ToUpper(number)
+	for _, u := range byteUnits {
+		if strings.HasSuffix(upper, u.suffix) {
+			number, unit = strings.TrimSpace(number[:len(number)-len(u.suffix)]), u.size
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(number, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q (e.g. 200KB, 1.5MB)", s)
+	}
+	return int64(n * float64(unit)), nil
+}
+
+// summarize runs the --summarizer command through the shell with the file's
+// content on stdin and its path in FLATTEN_PATH, returning what it prints
+func summarize(path string, content []byte) (string, error) {
+	cmd := exec.Command("sh", "-c", summarizer)
+	cmd.Env = append(os.Environ(), "FLATTEN_PATH="+path)
+	cmd.Stdin = bytes.NewReader(content)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("%w: %s", err, msg)
+		}
+		return "", err
+	}
+	summary := strings.TrimSpace(string(out))
+	if summary == "" {
+		return "", fmt.Errorf("summarizer printed nothing")
+	}
+	return summary, nil
+}
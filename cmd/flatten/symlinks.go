@@ -0,0 +1,56 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// realPath resolves every symlink in path and makes it absolute
+func realPath(path string) (string, error) {
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Abs(resolved)
+}
+
+// isWithin reports whether path is dir or lies below it
+func isWithin(path string, dir string) bool {
+	return path == dir || strings.HasPrefix(path, dir+string(filepath.Separator))
+}
+
+// checkSymlink inspects the entry at fullPath on the OS file system. It
+// returns the link target when the entry is a symlink, and an exclusion
+// reason when the link must not be followed: because --follow-symlinks is
+// off, because it resolves outside the root under --confine-to-root, or
+// because a directory link points back at one of its own ancestors.
+func (w *walkState) checkSymlink(fullPath string) (target string, reason string) {
+	if w.realRoot == "" {
+		return "", ""
+	}
+	info, err := os.Lstat(fullPath)
+	if err != nil || info.Mode()&os.ModeSymlink == 0 {
+		return "", ""
+	}
+	target, err = os.Readlink(fullPath)
+	if err != nil {
+		return "", ""
+	}
+	if !followSymlinks {
+		return target, ReasonSymlink
+	}
+	resolved, err := realPath(fullPath)
+	if err != nil {
+		return target, ""
+	}
+	if confineToRoot && !isWithin(resolved, w.realRoot) {
+		warn(warnOutsideRoot, fullPath, "symlink resolves to %s, outside the root; not followed (--confine-to-root)", resolved)
+		return target, ReasonOutsideRoot
+	}
+	if parent, err := realPath(filepath.Dir(fullPath)); err == nil && isWithin(parent, resolved) {
+		warn(warnSymlinkLoop, fullPath, "symlink points to its own ancestor %s; not followed", resolved)
+		return target, ReasonSymlink
+	}
+	return target, ""
+}
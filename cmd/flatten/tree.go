@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// treeFormats lists the values of --tree-format
+var treeFormats = []string{"unicode", "indent", "paths", "json"}
+
+var treeFormat string
+
+// validateTreeFormat checks the --tree-format value
+func validateTreeFormat() error {
+	for _, format := range treeFormats {
+		if treeFormat == format {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid --tree-format value %q (valid: %s)", treeFormat, strings.Join(treeFormats, ", "))
+}
+
+// renderTree draws the tree below root in the --tree-format format
+func renderTree(root *FileEntry, showTokens bool) (string, error) {
+	switch treeFormat {
+	case "indent":
+		var sb strings.Builder
+		renderIndentTree(&sb, root, 0, showTokens)
+		return sb.String(), nil
+	case "paths":
+		var sb strings.Builder
+		renderPathTree(&sb, root)
+		return sb.String(), nil
+	case "json":
+		data, err := json.MarshalIndent(newTreeNode(root, root.Path), "", "  ")
+		if err != nil {
+			return "", fmt.Errorf("failed to encode tree: %w", err)
+		}
+		return string(data) + "\n", nil
+	}
+	return renderDirTree(root, "", false, showTokens), nil
+}
+
+// renderIndentTree draws the tree as a plain list indented by two spaces a
+// level, with a slash after directory names
+func renderIndentTree(sb *strings.Builder, entry *FileEntry, depth int, showTokens bool) {
+	if entry.Path != "." {
+		name := filepath.Base(entry.Path)
+		if entry.IsDir {
+			name += "/"
+		}
+		sb.WriteString(strings.Repeat("  ", depth) + treeLabel(entry, name, showTokens) + "\n")
+		depth++
+	}
+	for _, child := range entry.Children {
+		renderIndentTree(sb, child, depth, showTokens)
+	}
+}
+
+// renderPathTree lists the path of every entry, with a slash after
+// directories
+func renderPathTree(sb *strings.Builder, entry *FileEntry) {
+	if entry.Path != "." {
+		sb.WriteString(entry.Path)
+		if entry.IsDir {
+			sb.WriteString("/")
+		}
+		sb.WriteString("\n")
+	}
+	for _, child := range entry.Children {
+		renderPathTree(sb, child)
+	}
+}
+
+// treeNode is an entry of the --tree-format json tree
+type treeNode struct {
+	Name       string      `json:"name"`
+	Type       string      `json:"type"`
+	Size       *int64      `json:"size,omitempty"`
+	Tokens     int         `json:"tokens,omitempty"`
+	LinkTarget string      `json:"link_target,omitempty"`
+	Submodule  string      `json:"submodule,omitempty"`
+	Project    string      `json:"project,omitempty"`
+	Children   []*treeNode `json:"children,omitempty"`
+}
+
+func newTreeNode(entry *FileEntry, name string) *treeNode {
+	node := &treeNode{
+		Name:       name,
+		Type:       "file",
+		Tokens:     entry.Tokens,
+		LinkTarget: entry.LinkTarget,
+		Submodule:  entry.Submodule,
+	}
+	if entry.project != nil {
+		node.Project = entry.project.String()
+	}
+	if !entry.IsDir {
+		size := entry.Size
+		node.Size = &size
+		return node
+	}
+	node.Type = "dir"
+	for _, child := range entry.Children {
+		node.Children = append(node.Children, newTreeNode(child, filepath.Base(child.Path)))
+	}
+	return node
+}
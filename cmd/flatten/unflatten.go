@@ -0,0 +1,436 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// porcelainRecord is a "file" or "dir" record read back from a porcelain
+// stream
+type porcelainRecord struct {
+	root        string
+	path        string
+	size        int64
+	mode        string
+	modTime     int64
+	hash        string
+	duplicateOf string
+	linkTarget  string
+	// content is nil when the stream omitted it
+	content []byte
+	// dir is set for directory records, which only carry the path, mode,
+	// mtime and the number of children
+	dir      bool
+	children int
+}
+
+// porcelainReader parses the stream written by --porcelain
+type porcelainReader struct {
+	r       *bufio.Reader
+	sep     byte
+	version int
+	root    string
+}
+
+// newPorcelainReader checks the stream header and detects the separator
+func newPorcelainReader(r io.Reader) (*porcelainReader, error) {
+	br := bufio.NewReader(r)
+	magic := []byte("flatten-porcelain")
+	head, err := br.Peek(len(magic) + 1)
+	if err != nil || !bytes.Equal(head[:len(magic)], magic) {
+		return nil, fmt.Errorf("input is not flatten --porcelain output")
+	}
+	pr := &porcelainReader{r: br, sep: head[len(magic)]}
+	if pr.sep != 0 && pr.sep != '\n' {
+		return nil, fmt.Errorf("input is not flatten --porcelain output")
+	}
+	pr.readField()
+	version, err := pr.readField()
+	if err != nil {
+		return nil, err
+	}
+	// Version 1 lacked the link-target field but is otherwise identical
+	pr.version, err = strconv.Atoi(version)
+	if err != nil || pr.version < 1 || pr.version > porcelainVersion {
+		return nil, fmt.Errorf("unsupported porcelain version %q", version)
+	}
+	return pr, nil
+}
+
+func (pr *porcelainReader) readField() (string, error) {
+	field, err := pr.r.ReadString(pr.sep)
+	if err != nil {
+		if err == io.EOF && field != "" {
+			return "", io.ErrUnexpectedEOF
+		}
+		return "", err
+	}
+	return strings.TrimSuffix(field, string(pr.sep)), nil
+}
+
+func (pr *porcelainReader) readFields(n int) ([]string, error) {
+	fields := make([]string, n)
+	for i := range fields {
+		field, err := pr.readField()
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return nil, err
+		}
+		fields[i] = field
+	}
+	return fields, nil
+}
+
+// next returns the next file or directory record, skipping the other record
+// types. It returns io.EOF at the end of the stream.
+func (pr *porcelainReader) next() (*porcelainRecord, error) {
+	for {
+		kind, err := pr.readField()
+		if err != nil {
+			return nil, err
+		}
+		switch kind {
+		case "root":
+			fields, err := pr.readFields(1)
+			if err != nil {
+				return nil, err
+			}
+			pr.root = fields[0]
+		case "warning":
+			if _, err := pr.readFields(3); err != nil {
+				return nil, err
+			}
+		case "dir":
+			fields, err := pr.readFields(4)
+			if err != nil {
+				return nil, err
+			}
+			return newDirRecord(pr.root, fields)
+		case "file":
+			return pr.readFile()
+		default:
+			return nil, fmt.Errorf("unknown porcelain record %q", kind)
+		}
+	}
+}
+
+func (pr *porcelainReader) readFile() (*porcelainRecord, error) {
+	n := 8
+	if pr.version == 1 {
+		n = 7
+	}
+	fields, err := pr.readFields(n)
+	if err != nil {
+		return nil, err
+	}
+	if pr.version == 1 {
+		fields = append(fields[:6], "", fields[6])
+	}
+	rec, err := newPorcelainRecord(pr.root, fields)
+	if err != nil {
+		return nil, err
+	}
+	if fields[7] == "-" {
+		return rec, nil
+	}
+	length, err := strconv.ParseInt(fields[7], 10, 64)
+	if err != nil || length < 0 {
+		return nil, fmt.Errorf("invalid content length for %s", rec.path)
+	}
+	if rec.content, err = readContent(pr.r, length); err != nil {
+		return nil, fmt.Errorf("truncated content for %s: %w", rec.path, err)
+	}
+	if sep, err := pr.r.ReadByte(); err != nil || sep != pr.sep {
+		return nil, fmt.Errorf("malformed content for %s", rec.path)
+	}
+	return rec, nil
+}
+
+// readContent reads a content field of length bytes. The length comes from
+// the stream, so memory only grows with the bytes actually read, and a
+// stream that ends early is an error rather than a huge allocation.
+func readContent(r io.Reader, length int64) ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := io.CopyN(&buf, r, length); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	// Empty content is still content, unlike nil
+	if buf.Len() == 0 {
+		return []byte{}, nil
+	}
+	return buf.Bytes(), nil
+}
+
+// newPorcelainRecord parses the metadata fields of a file record, from path
+// through link-target
+func newPorcelainRecord(root string, fields []string) (*porcelainRecord, error) {
+	rec := &porcelainRecord{
+		root:        root,
+		path:        fields[0],
+		mode:        fields[2],
+		hash:        fields[4],
+		duplicateOf: fields[5],
+		linkTarget:  fields[6],
+	}
+	var err error
+	if rec.size, err = strconv.ParseInt(fields[1], 10, 64); err != nil {
+		return nil, fmt.Errorf("invalid size for %s: %w", rec.path, err)
+	}
+	if rec.modTime, err = strconv.ParseInt(fields[3], 10, 64); err != nil {
+		return nil, fmt.Errorf("invalid mtime for %s: %w", rec.path, err)
+	}
+	return rec, nil
+}
+
+// newDirRecord parses the fields of a directory record: path, mode, mtime and
+// the number of children
+func newDirRecord(root string, fields []string) (*porcelainRecord, error) {
+	rec := &porcelainRecord{root: root, path: fields[0], mode: fields[1], dir: true}
+	var err error
+	if rec.modTime, err = strconv.ParseInt(fields[2], 10, 64); err != nil {
+		return nil, fmt.Errorf("invalid mtime for %s: %w", rec.path, err)
+	}
+	if rec.children, err = strconv.Atoi(fields[3]); err != nil {
+		return nil, fmt.Errorf("invalid child count for %s: %w", rec.path, err)
+	}
+	return rec, nil
+}
+
+// snapshotReader yields the file and directory records of a stored snapshot
+type snapshotReader interface {
+	// next returns the next record, or io.EOF at the end of the stream
+	next() (*porcelainRecord, error)
+}
+
+// openSnapshot detects whether r holds porcelain or fbin output
+func openSnapshot(r io.Reader) (snapshotReader, error) {
+	br := bufio.NewReader(r)
+	if head, err := br.Peek(len(fbinMagic)); err == nil && string(head) == fbinMagic {
+		return newFbinReader(br)
+	}
+	return newPorcelainReader(br)
+}
+
+// safeTargetPath maps a recorded path to its location inside target. The
+// path, taken relative to its root, must stay inside target: absolute paths
+// and ".." components are rejected, as are paths leading through a symlink
+// already present in target, since snapshots may come from untrusted sources.
+func safeTargetPath(target string, root string, recorded string) (string, error) {
+	rel := recorded
+	if root != "" && root != "." {
+		r, err := filepath.Rel(root, recorded)
+		if err != nil {
+			return "", fmt.Errorf("unsafe path %q: not under its root %q", recorded, root)
+		}
+		rel = r
+	}
+	if !filepath.IsLocal(rel) {
+		return "", fmt.Errorf("unsafe path %q: escapes the target directory", recorded)
+	}
+	dest := target
+	parts := strings.Split(rel, string(filepath.Separator))
+	for i, part := range parts {
+		dest = filepath.Join(dest, part)
+		info, err := os.Lstat(dest)
+		if os.IsNotExist(err) {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return "", fmt.Errorf("unsafe path %q: %s is a symlink", recorded, dest)
+		}
+		if i < len(parts)-1 && !info.IsDir() {
+			return "", fmt.Errorf("unsafe path %q: %s is not a directory", recorded, dest)
+		}
+	}
+	return filepath.Join(target, rel), nil
+}
+
+// parseFileMode reverses fs.FileMode.String for the permission and special
+// bits, e.g. "ug-rwxr-xr-x" is setuid and setgid with 0755 permissions
+func parseFileMode(s string) (os.FileMode, error) {
+	if len(s) < 9 {
+		return 0, fmt.Errorf("invalid mode %q", s)
+	}
+	perm, flags := s[len(s)-9:], s[:len(s)-9]
+	var mode os.FileMode
+	for i, c := range perm {
+		if c != '-' {
+			mode |= 1 << uint(8-i)
+		}
+	}
+	for _, c := range flags {
+		switch c {
+		case 'u':
+			mode |= os.ModeSetuid
+		case 'g':
+			mode |= os.ModeSetgid
+		case 't':
+			mode |= os.ModeSticky
+		}
+	}
+	return mode, nil
+}
+
+// restoreMetadata applies the recorded mode and mtime to a written file.
+// Setuid and setgid bits are dropped unless --setid asks for them, since
+// snapshots may come from untrusted sources.
+func restoreMetadata(dest string, rec *porcelainRecord) error {
+	mode, err := parseFileMode(rec.mode)
+	if err != nil {
+		return fmt.Errorf("%s: %w", rec.path, err)
+	}
+	if !unflattenSetID {
+		mode &^= os.ModeSetuid | os.ModeSetgid
+	}
+	if err := os.Chmod(dest, mode); err != nil {
+		return err
+	}
+	mtime := time.Unix(rec.modTime, 0)
+	return os.Chtimes(dest, mtime, mtime)
+}
+
+// unflatten recreates the files of a porcelain or fbin stream below target. With
+// preserve, recorded modes and mtimes are restored and files that were
+// reached through symlinks are recreated as symlinks. Directory records,
+// written with --dir-records, recreate empty directories too; their
+// metadata is restored once everything inside them has been written.
+func unflatten(r io.Reader, target string, preserve bool) (int, error) {
+	sr, err := openSnapshot(r)
+	if err != nil {
+		return 0, err
+	}
+	if err := os.MkdirAll(target, 0o755); err != nil {
+		return 0, err
+	}
+	// Duplicates take the content their source carried in the stream; what
+	// was written is never read back, as it may lead anywhere through a
+	// restored symlink
+	contents := make(map[string][]byte)
+	links := make(map[string]bool)
+	var dirs []*porcelainRecord
+	var dirDests []string
+	count := 0
+	for {
+		rec, err := sr.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return count, err
+		}
+		dest, err := safeTargetPath(target, rec.root, rec.path)
+		if err != nil {
+			return count, err
+		}
+		if rec.dir {
+			if err := os.MkdirAll(dest, 0o755); err != nil {
+				return count, err
+			}
+			dirs = append(dirs, rec)
+			dirDests = append(dirDests, dest)
+			continue
+		}
+		if preserve && rec.linkTarget != "" {
+			if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+				return count, err
+			}
+			if err := os.Symlink(rec.linkTarget, dest); err != nil {
+				return count, err
+			}
+			links[rec.path] = true
+			count++
+			continue
+		}
+		content := rec.content
+		if content == nil {
+			if links[rec.duplicateOf] {
+				warn(warnUnreadable, rec.path, "duplicate of %s, which was restored as a symlink; skipped", rec.duplicateOf)
+				continue
+			}
+			var ok bool
+			if content, ok = contents[rec.duplicateOf]; rec.duplicateOf == "" || !ok {
+				warn(warnUnreadable, rec.path, "content not included in the input; skipped")
+				continue
+			}
+		}
+		contents[rec.path] = content
+		if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+			return count, err
+		}
+		if err := os.WriteFile(dest, content, 0o644); err != nil {
+			return count, err
+		}
+		if preserve {
+			if err := restoreMetadata(dest, rec); err != nil {
+				return count, err
+			}
+		}
+		count++
+	}
+	if preserve {
+		// Directories precede their contents, so restoring in reverse sets
+		// each one after its subdirectories
+		for i := len(dirs) - 1; i >= 0; i-- {
+			if err := restoreMetadata(dirDests[i], dirs[i]); err != nil {
+				return count, err
+			}
+		}
+	}
+	return count, nil
+}
+
+var (
+	unflattenInput    string
+	unflattenPreserve bool
+	unflattenSetID    bool
+)
+
+var unflattenCmd = &cobra.Command{
+	Use:   "unflatten <target-directory>",
+	Short: "Recreate files from flatten --porcelain or --format fbin output",
+	Long: `Unflatten reads the output of flatten --porcelain (with or without -0)
+or --format fbin from stdin or --input and writes the files it contains below the target
+directory. Paths that would escape the target directory are rejected.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var in io.Reader = os.Stdin
+		if unflattenInput != "" {
+			file, err := os.Open(unflattenInput)
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			in = file
+		}
+		count, err := unflatten(in, args[0], unflattenPreserve)
+		if err != nil {
+			return fmt.Errorf("unflatten failed after %d files: %w", count, err)
+		}
+		fmt.Fprintf(os.Stderr, "unflattened %d files into %s\n", count, args[0])
+		return nil
+	},
+}
+
+func init() {
+	unflattenCmd.Flags().StringVar(&unflattenInput, "input", "", "Read porcelain or fbin output from this file instead of stdin")
+	unflattenCmd.Flags().BoolVar(&unflattenPreserve, "preserve", false, "Restore recorded file modes, mtimes and symlinks")
+	unflattenCmd.Flags().BoolVar(&unflattenSetID, "setid", false, "With --preserve, also restore setuid and setgid bits")
+	rootCmd.AddCommand(unflattenCmd)
+}
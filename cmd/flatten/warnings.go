@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+)
+
+// Warning kinds
+const (
+	warnUnreadable    = "unreadable"
+	warnSymlinkLoop   = "symlink-loop"
+	warnEncoding      = "encoding"
+	warnSparse        = "sparse-checkout"
+	warnSubmodule     = "submodule"
+	warnTruncated     = "truncated"
+	warnOutsideRoot   = "outside-root"
+	warnSummarizer    = "summarizer"
+	warnIgnoreRead    = "ignore-file"
+	warnHashMismatch  = "hash-mismatch"
+	warnChanged       = "changed"
+	warnMetadataCache = "metadata-cache"
+	warnInterrupted   = "interrupted"
+	warnFSInfo        = "fs-info"
+)
+
+// Warning describes a non-fatal problem encountered during a run
+type Warning struct {
+	Kind    string `json:"kind"`
+	Path    string `json:"path,omitempty"`
+	Message string `json:"message"`
+}
+
+// runWarnings collects the warnings raised during the current run
+var runWarnings []Warning
+
+// warn records a warning and echoes it to stderr. Repeats of the same warning
+// (e.g. a file that fails to read for both the sidecar and the output) are
+// only recorded once.
+func warn(kind string, path string, format string, args ...any) {
+	w := Warning{Kind: kind, Path: path, Message: fmt.Sprintf(format, args...)}
+	for _, existing := range runWarnings {
+		if existing == w {
+			return
+		}
+	}
+	runWarnings = append(runWarnings, w)
+	fmt.Fprintf(os.Stderr, "warning: %s\n", w)
+}
+
+// warnReadError records a failure to read path, classifying symlink loops
+func warnReadError(path string, err error) {
+	if errors.Is(err, syscall.ELOOP) {
+		warn(warnSymlinkLoop, path, "too many levels of symbolic links")
+		return
+	}
+	warn(warnUnreadable, path, "%v", err)
+}
+
+func (w Warning) String() string {
+	if w.Path == "" {
+		return fmt.Sprintf("[%s] %s", w.Kind, w.Message)
+	}
+	return fmt.Sprintf("[%s] %s: %s", w.Kind, w.Path, w.Message)
+}
+
+// writeWarnings appends the warnings section to the human-readable output
+func writeWarnings(w *strings.Builder) {
+	if len(runWarnings) == 0 {
+		return
+	}
+	w.WriteString(fmt.Sprintf("\n%s: %d\n", label("warnings"), len(runWarnings)))
+	for _, warning := range runWarnings {
+		w.WriteString(fmt.Sprintf("- %s\n", warning))
+	}
+}
+
+// writeWarningsJSON writes the warnings as a JSON array
+func writeWarningsJSON(path string) error {
+	warnings := runWarnings
+	if warnings == nil {
+		warnings = []Warning{}
+	}
+	data, err := json.MarshalIndent(warnings, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode warnings: %w", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("failed to write warnings %s: %w", path, err)
+	}
+	return nil
+}